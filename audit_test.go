@@ -0,0 +1,157 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func auditRecords(t *testing.T, data []byte) []auditRecord {
+	t.Helper()
+	var recs []auditRecord
+	for line := range strings.Lines(string(data)) {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestAuditLog(t *testing.T) {
+	var log bytes.Buffer
+	s := newTestStore(t, WithAuditLog(&log), WithRemote(newTestRegistry(t)+"/repo:main"))
+	mustPut(t, s, "a", "1")
+	mustPut(t, s, "b", "2")
+	s.Delete("a")
+	if err := s.Push(WithActor(context.Background(), "ci")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	recs := auditRecords(t, log.Bytes())
+	var ops []string
+	for _, rec := range recs {
+		ops = append(ops, rec.Op+" "+rec.Key)
+	}
+	if got, want := strings.Join(ops, ","), "put a,put b,delete a,push main"; got != want {
+		t.Errorf("audit ops = %s, want %s", got, want)
+	}
+	if last := recs[len(recs)-1]; last.Actor != "ci" {
+		t.Errorf("push actor = %q, want ci", last.Actor)
+	}
+	if err := VerifyAuditLog(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+
+	lines := strings.SplitAfter(log.String(), "\n")
+	tests := map[string]string{
+		"edited":    lines[0] + strings.Replace(lines[1], `"key":"b"`, `"key":"c"`, 1) + strings.Join(lines[2:], ""),
+		"removed":   lines[0] + strings.Join(lines[2:], ""),
+		"reordered": lines[1] + lines[0] + strings.Join(lines[2:], ""),
+	}
+	for name, tampered := range tests {
+		if err := VerifyAuditLog(strings.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "chain broken") {
+			t.Errorf("%s line: VerifyAuditLog = %v, want a broken chain", name, err)
+		}
+	}
+}
+
+func TestAuditLogFileSpansOpens(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, key := range []string{"a", "b"} {
+		s, err := Open("test:main", WithCacheDir(dir), WithAuditLogFile(path))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		mustPut(t, s, key, key)
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs := auditRecords(t, data); len(recs) != 2 || recs[1].Prev == "" {
+		t.Fatalf("audit file = %s", data)
+	}
+	if err := VerifyAuditLog(bytes.NewReader(data)); err != nil {
+		t.Errorf("VerifyAuditLog across opens: %v", err)
+	}
+}
+
+func TestAuditFailureLeavesEntriesUntouched(t *testing.T) {
+	w := new(failingWriter)
+	s := newTestStore(t, WithAuditLog(w))
+	mustPut(t, s, "a", "old a")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	w.fail.Store(true)
+	if err := s.Put("a", []byte("new a")); err == nil {
+		t.Error("Put succeeded without an audit record")
+	}
+	if err := s.Put("b", []byte("b")); err == nil {
+		t.Error("Put of a new key succeeded without an audit record")
+	}
+	if err := s.MkdirEntry("dir"); err == nil {
+		t.Error("MkdirEntry succeeded without an audit record")
+	}
+	if got := mustGet(t, s, "a"); got != "old a" {
+		t.Errorf("a = %q after a failed Put, want %q", got, "old a")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len = %d after failed writes, want 1", s.Len())
+	}
+	if s.dirty.Load() {
+		t.Error("failed writes marked the index dirty")
+	}
+}
+
+func TestVerifyAuditLogSkipsBlankLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := Open("test:main", WithCacheDir(t.TempDir()), WithAuditLogFile(path))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	mustPut(t, s, "a", "a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A trailing blank line, e.g. from an editor, is skipped on reopen and
+	// must be skipped by the verifier too.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err = Open("test:main", WithCacheDir(t.TempDir()), WithAuditLogFile(path))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	mustPut(t, s, "b", "b")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAuditLog(bytes.NewReader(data)); err != nil {
+		t.Errorf("VerifyAuditLog with a blank line: %v", err)
+	}
+}
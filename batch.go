@@ -0,0 +1,96 @@
+package cafs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aweris/cafs/internal/remote"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// PutMulti stores every item in parallel, applying opts to each. A failing
+// key doesn't stop the others; failures are returned as a *BatchError.
+func (s *CAS) PutMulti(items map[string][]byte, opts ...Option) error {
+	var mu sync.Mutex
+	failed := make(map[string]error)
+
+	p := pool.New().WithMaxGoroutines(s.concurrency)
+	for key, data := range items {
+		p.Go(func() {
+			if err := s.Put(key, data, opts...); err != nil {
+				mu.Lock()
+				failed[key] = err
+				mu.Unlock()
+			}
+		})
+	}
+	p.Wait()
+
+	if len(failed) > 0 {
+		return &BatchError{Errors: failed}
+	}
+	return nil
+}
+
+// GetMulti reads keys in parallel. It returns the content of every key that
+// could be read, plus a *BatchError listing the ones that couldn't.
+func (s *CAS) GetMulti(keys []string) (map[string][]byte, error) {
+	var mu sync.Mutex
+	result := make(map[string][]byte, len(keys))
+	failed := make(map[string]error)
+
+	p := pool.New().WithMaxGoroutines(s.concurrency)
+	for _, key := range keys {
+		p.Go(func() {
+			data, err := s.Get(key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[key] = err
+				return
+			}
+			result[key] = data
+		})
+	}
+	p.Wait()
+
+	if len(failed) > 0 {
+		return result, &BatchError{Errors: failed}
+	}
+	return result, nil
+}
+
+// PushAll pushes several stores, such as per-module caches, concurrently.
+// At most remote.DefaultConcurrency stores push at once, and together they
+// keep at most remote.DefaultConcurrency registry requests in flight, the
+// default for a single push, however many stores there are. A failing store
+// doesn't stop the others; failures are returned as a *BatchError keyed by
+// each store's Ref.
+func PushAll(ctx context.Context, stores ...Store) error {
+	ctx = remote.WithRequestLimit(ctx, remote.DefaultConcurrency)
+
+	var mu sync.Mutex
+	failed := make(map[string]error)
+
+	p := pool.New().WithMaxGoroutines(remote.DefaultConcurrency)
+	for i, s := range stores {
+		p.Go(func() {
+			if err := s.Push(ctx); err != nil {
+				name := s.Ref()
+				mu.Lock()
+				defer mu.Unlock()
+				if _, dup := failed[name]; dup || name == "" {
+					name = fmt.Sprintf("%s#%d", name, i)
+				}
+				failed[name] = err
+			}
+		})
+	}
+	p.Wait()
+
+	if len(failed) > 0 {
+		return &BatchError{Errors: failed}
+	}
+	return nil
+}
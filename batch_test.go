@@ -0,0 +1,81 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPutMultiGetMulti(t *testing.T) {
+	s := newTestStore(t)
+	err := s.PutMulti(map[string][]byte{
+		"a":    []byte("1"),
+		"b/c":  []byte("2"),
+		"_bad": []byte("3"),
+	})
+	var batch *BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("PutMulti: err = %v, want *BatchError", err)
+	}
+	if keys := slices.Sorted(maps.Keys(batch.Errors)); !slices.Equal(keys, []string{"_bad"}) {
+		t.Errorf("failed keys = %v, want [_bad]", keys)
+	}
+	if !errors.Is(err, ErrReservedKey) {
+		t.Errorf("BatchError doesn't unwrap to ErrReservedKey: %v", err)
+	}
+
+	got, err := s.GetMulti([]string{"a", "b/c", "missing"})
+	if !errors.As(err, &batch) || len(batch.Errors) != 1 || !errors.Is(batch.Errors["missing"], ErrNotFound) {
+		t.Fatalf("GetMulti: err = %v, want ErrNotFound for missing only", err)
+	}
+	if len(got) != 2 || string(got["a"]) != "1" || string(got["b/c"]) != "2" {
+		t.Errorf("GetMulti = %q", got)
+	}
+
+	if _, err := s.GetMulti([]string{"a"}); err != nil {
+		t.Errorf("GetMulti of present keys: %v", err)
+	}
+}
+
+func TestPushAll(t *testing.T) {
+	ctx := context.Background()
+	host := newTestRegistry(t)
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(forbidden.Close)
+
+	var stores []Store
+	for _, mod := range []string{"api", "web", "worker"} {
+		s := newTestStore(t, WithRemote(host+"/"+mod+":main"))
+		mustPut(t, s, "out", mod)
+		stores = append(stores, s)
+	}
+	denied := newTestStore(t, WithRemote(strings.TrimPrefix(forbidden.URL, "http://")+"/denied:main"))
+	mustPut(t, denied, "out", "x")
+	local := newTestStore(t)
+
+	err := PushAll(ctx, append(stores, denied, local)...)
+	var batch *BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("PushAll: err = %v, want *BatchError", err)
+	}
+	if len(batch.Errors) != 2 || batch.Errors[denied.Ref()] == nil {
+		t.Errorf("failures = %v, want the denied and local stores", batch)
+	}
+	if !errors.Is(err, ErrNoRemote) {
+		t.Errorf("BatchError doesn't unwrap to ErrNoRemote: %v", err)
+	}
+
+	for _, s := range stores {
+		fresh := newTestStore(t, WithRemote(s.Ref()))
+		if err := fresh.Pull(ctx); err != nil {
+			t.Errorf("Pull %s: %v", s.Ref(), err)
+		}
+	}
+}
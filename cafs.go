@@ -1,18 +1,26 @@
 package cafs
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"iter"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/aweris/cafs/internal/remote"
 )
@@ -23,16 +31,116 @@ const digestPrefix = "sha256:"
 type CAS struct {
 	blobs     *blobStore
 	entries   sync.Map // key -> Info
+	inline    sync.Map // Digest -> []byte, for blobs stored in the index
+	chunks    sync.Map // Digest -> []Digest, for blobs pushed as chunks
+	prefixes  sync.Map // key prefix -> remote.PrefixInfo
+	metaIndex *metaIndex
+	indexMu   sync.RWMutex // held to change entries; read-held for whole-index reads
 	remote    *remote.OCIRemote
+	fallbacks []*remote.OCIRemote // tried in order when the primary fails to pull
 	namespace string
 	tag       string
 	cacheDir  string
 	dirty     atomic.Bool
+	syncErr   atomic.Pointer[error]
+
+	// Background work (e.g. deferred pushes) runs under bgCtx and is
+	// tracked by bg so CloseContext can wait for or cancel it.
+	bg       sync.WaitGroup
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	debounce *pushDebouncer // set by WithPushDebounce
+	pushMu   sync.Mutex     // one push at a time
+	syncMu   sync.Mutex     // one Sync at a time
+
+	indexInLayerOnly bool
+	persistTrees     bool
+	chunking         bool
+	pullStrategy     string
+	mirrorPush       bool
+	inlineThreshold  int
+	concurrency      int // parallelism for batch operations
+	transferHook     func(TransferReport)
+	audit            *auditLog
+	readOnly         bool            // historical snapshots and remote-backed stores
+	lazy             *lazyLayers     // set for stores from OpenRemote
+	ephemeral        bool            // clones: never synced, lazy cache owned by the original
+	origin           *CAS            // for clones, the store they were cloned from
+	base             map[string]Info // for clones, the entries MergeFrom diffs against
+	clones           sync.Map        // *CAS -> struct{}, open clones whose blobs GC keeps
+	indexBackups     int             // previous indexes kept as tag.json.1..N
+	hashIgnoreSize   bool            // Hash lines omit the recorded size
+	verifyAfterPush  bool            // read pushes back before trusting them
+	keyValidator     func(string) error
+	keyNormalizer    func(string) string
 }
 
 // Open creates or opens a store for the given namespace.
 // Format: "namespace" or "namespace:tag" (default tag is "latest").
 func Open(namespace string, opts ...OpenOption) (Store, error) {
+	s, options, err := newStore(namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadLocalIndex(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("load index: %w", err)
+		if options.StrictOpen {
+			s.audit.close()
+			return nil, err
+		}
+		s.setSyncErr(err)
+	}
+
+	if s.remote != nil && (options.AutoPull == AutoPullAlways || options.AutoPull == AutoPullMissing) {
+		if err := s.autoPull(options.OpenTimeout); err != nil && options.StrictOpen {
+			s.audit.close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// OpenAtTime opens the snapshot of namespace that was most recently pushed or
+// pulled at or before t, according to the local ref-log. The snapshot must
+// still be in the local blob store (GC keeps only blobs referenced by the
+// current index). The returned store is read-only.
+func OpenAtTime(namespace string, t time.Time, opts ...OpenOption) (Store, error) {
+	s, _, err := newStore(namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.readOnly = true
+	s.audit.close() // read-only stores record nothing
+	s.audit = nil
+
+	entries, err := readReflog(s.reflogPath())
+	if err != nil {
+		return nil, fmt.Errorf("read ref-log: %w", err)
+	}
+	var root Digest
+	for _, e := range entries {
+		if !e.Time.After(t) {
+			root = e.Root
+		}
+	}
+	if root == "" {
+		return nil, fmt.Errorf("no snapshot of %s:%s at or before %s: %w", s.namespace, s.tag, t.Format(time.RFC3339), ErrNotFound)
+	}
+
+	data, err := s.blobs.Get(root)
+	if err != nil {
+		return nil, fmt.Errorf("load snapshot %s: %w", root, err)
+	}
+	if err := s.load(data); err != nil {
+		return nil, fmt.Errorf("parse snapshot %s: %w", root, err)
+	}
+	return s, nil
+}
+
+func newStore(namespace string, opts []OpenOption) (*CAS, *OpenOptions, error) {
 	options := defaultOptions()
 	for _, opt := range opts {
 		opt(options)
@@ -40,56 +148,130 @@ func Open(namespace string, opts ...OpenOption) (Store, error) {
 
 	ns, tag := parseNamespace(namespace)
 	if ns == "" {
-		return nil, fmt.Errorf("namespace is required")
+		return nil, nil, fmt.Errorf("namespace is required")
 	}
 
 	cacheDir := expandPath(options.CacheDir)
 	blobDir := filepath.Join(cacheDir, ns, "blobs", "sha256")
 	if err := os.MkdirAll(blobDir, 0755); err != nil {
-		return nil, fmt.Errorf("create blob dir: %w", err)
+		return nil, nil, fmt.Errorf("create blob dir: %w", err)
 	}
 
 	s := &CAS{
-		blobs:     &blobStore{dir: blobDir},
+		blobs:     newBlobStore(blobDir),
 		namespace: ns,
 		tag:       tag,
 		cacheDir:  cacheDir,
+		metaIndex: newMetaIndex(options.SecondaryIndexes),
+
+		indexInLayerOnly: options.IndexInLayerOnly,
+		persistTrees:     options.PersistTrees,
+		chunking:         options.Chunking,
+		pullStrategy:     options.PullStrategy,
+		mirrorPush:       options.MirrorPush,
+		inlineThreshold:  options.InlineThreshold,
+		concurrency:      options.Concurrency,
+		transferHook:     options.TransferHook,
+		indexBackups:     options.IndexBackups,
+		hashIgnoreSize:   options.HashIgnoreSize,
+		verifyAfterPush:  options.VerifyAfterPush,
+		keyValidator:     options.KeyValidator,
+		keyNormalizer:    options.KeyNormalizer,
+	}
+	if options.PushDebounce > 0 {
+		s.debounce = &pushDebouncer{delay: options.PushDebounce}
 	}
+	s.bgCtx, s.bgCancel = context.WithCancel(context.Background())
 
 	// Setup remote if specified
 	if options.Remote != "" {
-		auth := options.Auth
-		if auth == nil {
-			auth = remote.NewDefaultAuthenticator()
-		}
-
-		ociRemote, err := remote.NewOCIRemote(options.Remote, auth)
+		ociRemote, err := newRemote(options.Remote, options)
 		if err != nil {
-			return nil, fmt.Errorf("invalid remote %q: %w", options.Remote, err)
+			return nil, nil, err
 		}
-		ociRemote.SetConcurrency(options.Concurrency)
 		s.remote = ociRemote
 	}
+	for _, ref := range options.Fallbacks {
+		ociRemote, err := newRemote(ref, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.fallbacks = append(s.fallbacks, ociRemote)
+	}
+	if options.AuditLogFile != "" {
+		audit, err := openAuditLog(options.AuditLogFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.audit = audit
+	} else if options.AuditLog != nil {
+		s.audit = &auditLog{w: options.AuditLog}
+	}
 
-	_ = s.loadLocalIndex()
+	return s, options, nil
+}
 
-	if s.remote != nil && (options.AutoPull == AutoPullAlways || options.AutoPull == AutoPullMissing) {
-		_ = s.Pull(context.Background())
+func newRemote(ref string, options *OpenOptions) (*remote.OCIRemote, error) {
+	auth := options.Auth
+	if auth == nil {
+		auth = remote.NewDefaultAuthenticator()
 	}
 
-	return s, nil
+	ociRemote, err := remote.NewOCIRemote(ref, auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote %q: %w", ref, err)
+	}
+	ociRemote.SetConcurrency(options.Concurrency)
+	ociRemote.SetTimestamps(options.PushTimestamps)
+	return ociRemote, nil
+}
+
+// autoPull pulls from the remote, giving up after timeout (if set). A failure
+// leaves the local store usable and is recorded for LastSyncError.
+func (s *CAS) autoPull(timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := s.Pull(ctx); err != nil {
+		err = &AutoPullError{Ref: s.remote.String(), Err: err}
+		s.setSyncErr(err)
+		return err
+	}
+	return nil
 }
 
-// parseNamespace splits "namespace:tag" into parts. Default tag is "latest".
+// parseNamespace splits "namespace[:tag]" the way image references are
+// split: a tag can only follow the last "/", so the port in a registry host
+// such as "localhost:5000/ns" is kept in the namespace. The tag defaults to
+// "latest", as it does for remotes.
 func parseNamespace(s string) (namespace, tag string) {
-	if idx := strings.LastIndex(s, ":"); idx != -1 {
-		return s[:idx], s[idx+1:]
+	idx := strings.LastIndex(s, ":")
+	if idx == -1 || idx < strings.LastIndex(s, "/") || idx == len(s)-1 {
+		return strings.TrimSuffix(s, ":"), "latest"
 	}
-	return s, "latest"
+	return s[:idx], s[idx+1:]
 }
 
 const maxKeyLength = 1024
 
+// checkKey applies the normalizer and validator set with WithKeyNormalizer
+// and WithKeyValidator, in that order, ahead of the built-in key rules.
+func (s *CAS) checkKey(key string) (string, error) {
+	if s.keyNormalizer != nil {
+		key = s.keyNormalizer(key)
+	}
+	if s.keyValidator != nil {
+		if err := s.keyValidator(key); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
 func validateKey(key string) error {
 	if key == "" {
 		return ErrInvalidKey
@@ -109,13 +291,71 @@ func validateKey(key string) error {
 	return nil
 }
 
+// validateFileKey validates a key for content; keys ending in "/" are
+// reserved for directory entries.
+func validateFileKey(key string) error {
+	if IsDirKey(key) {
+		return ErrInvalidKey
+	}
+	return validateKey(key)
+}
+
+// IsDirKey reports whether key is a directory entry created by MkdirEntry.
+func IsDirKey(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
+
+// MkdirEntry records an explicit directory at key, stored as key + "/", so
+// directories with no files survive export and restore. It appears in List
+// and contributes to Hash like any entry; use IsDirKey to tell it apart.
+func (s *CAS) MkdirEntry(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.checkKey(strings.TrimSuffix(key, "/"))
+	if err != nil {
+		return err
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	digest, err := s.putBlob(nil)
+	if err != nil {
+		return err
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if err := s.audit.record("mkdir", key+"/", digest, ""); err != nil {
+		return err
+	}
+	s.setEntry(key+"/", Info{
+		Digest: digest,
+		Meta:   FileMeta{Mode: os.ModeDir | 0755},
+	})
+	s.dirty.Store(true)
+	return nil
+}
+
 // Put stores data at key with optional metadata.
 func (s *CAS) Put(key string, data []byte, opts ...Option) error {
-	if err := validateKey(key); err != nil {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.checkKey(key)
+	if err != nil {
 		return err
 	}
+	if err := validateFileKey(key); err != nil {
+		return err
+	}
+	return s.put(key, data, opts...)
+}
 
-	digest, err := s.blobs.Put(data)
+// put is Put for a key that has already been normalized and checked.
+func (s *CAS) put(key string, data []byte, opts ...Option) error {
+	digest, err := s.putBlob(data)
 	if err != nil {
 		return err
 	}
@@ -129,23 +369,150 @@ func (s *CAS) Put(key string, data []byte, opts ...Option) error {
 		opt(&info)
 	}
 
-	s.entries.Store(key, info)
+	return s.storeEntry(key, info)
+}
+
+// storeEntry records info at key. Rewriting a key with the same content and
+// metadata is a no-op, so idempotent writes in sync loops don't dirty the
+// index.
+func (s *CAS) storeEntry(key string, info Info) error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	return s.storeEntryLocked(key, info)
+}
+
+func (s *CAS) storeEntryLocked(key string, info Info) error {
+	if old, ok := s.lookup(key); ok && sameEntry(old, info) {
+		return nil
+	}
+	// Audit first: a write the log can't record doesn't happen.
+	if err := s.audit.record("put", key, info.Digest, ""); err != nil {
+		return err
+	}
+	s.setEntry(key, info)
 	s.dirty.Store(true)
 	return nil
 }
 
-// Get retrieves data by key.
+func sameEntry(a, b Info) bool {
+	return a.Digest == b.Digest && a.Size == b.Size && reflect.DeepEqual(a.Meta, b.Meta)
+}
+
+// PutReader stores content read from r at key, streaming it to disk instead
+// of buffering it in memory.
+func (s *CAS) PutReader(key string, r io.Reader, opts ...Option) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if err := validateFileKey(key); err != nil {
+		return err
+	}
+
+	// Small content still goes through put so it can be inlined
+	if s.inlineThreshold > 0 {
+		head, err := io.ReadAll(io.LimitReader(r, int64(s.inlineThreshold)+1))
+		if err != nil {
+			return err
+		}
+		if len(head) <= s.inlineThreshold {
+			return s.put(key, head, opts...)
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	digest, size, err := s.blobs.PutReader(r)
+	if err != nil {
+		return err
+	}
+
+	info := Info{
+		Digest: digest,
+		Size:   size,
+	}
+
+	for _, opt := range opts {
+		opt(&info)
+	}
+
+	return s.storeEntry(key, info)
+}
+
+// PutBase64Stream stores the standard base64 encoded content read from r at
+// key, decoding it on the way through PutReader.
+func (s *CAS) PutBase64Stream(key string, r io.Reader, opts ...Option) error {
+	return s.PutReader(key, base64.NewDecoder(base64.StdEncoding, r), opts...)
+}
+
+// Get retrieves data by key. It fails with ErrSizeMismatch if the stored
+// content's length differs from the size recorded in the index.
 func (s *CAS) Get(key string) ([]byte, error) {
+	key, err := s.checkKey(key)
+	if err != nil {
+		return nil, err
+	}
 	v, ok := s.entries.Load(key)
 	if !ok {
 		return nil, ErrNotFound
 	}
 	info := v.(Info)
-	return s.blobs.Get(info.Digest)
+	data, err := s.readBlob(info.Digest)
+	if err != nil {
+		return nil, err
+	}
+	// A cheap guard against the index and blob store drifting apart, e.g.
+	// after an interrupted pull.
+	if int64(len(data)) != info.Size {
+		return nil, fmt.Errorf("%w: %s has %d bytes, index records %d", ErrSizeMismatch, key, len(data), info.Size)
+	}
+	return data, nil
+}
+
+// putBlob stores data inline when it is within the inline threshold, and as
+// a blob file otherwise.
+func (s *CAS) putBlob(data []byte) (Digest, error) {
+	if s.inlineThreshold > 0 && len(data) <= s.inlineThreshold {
+		digest := computeDigest(data)
+		s.inline.Store(digest, bytes.Clone(data))
+		return digest, nil
+	}
+	return s.blobs.Put(data)
+}
+
+// stageBlob is putBlob for content nothing references yet: a new blob file
+// is only queued for push once an entry refers to it (see blobStore.queue).
+func (s *CAS) stageBlob(data []byte) (Digest, error) {
+	if s.inlineThreshold > 0 && len(data) <= s.inlineThreshold {
+		return s.putBlob(data)
+	}
+	return s.blobs.stage(data)
+}
+
+// readBlob returns content by digest, from inline storage or disk.
+func (s *CAS) readBlob(digest Digest) ([]byte, error) {
+	if v, ok := s.inline.Load(digest); ok {
+		return bytes.Clone(v.([]byte)), nil
+	}
+	if s.lazy != nil {
+		return s.readLazy(digest)
+	}
+	return s.blobs.Get(digest)
 }
 
 // Stat returns metadata for key.
 func (s *CAS) Stat(key string) (Info, bool) {
+	key, err := s.checkKey(key)
+	if err != nil {
+		return Info{}, false
+	}
+	return s.lookup(key)
+}
+
+// lookup is Stat for a key that is already normalized.
+func (s *CAS) lookup(key string) (Info, bool) {
 	v, ok := s.entries.Load(key)
 	if !ok {
 		return Info{}, false
@@ -153,21 +520,75 @@ func (s *CAS) Stat(key string) (Info, bool) {
 	return v.(Info), true
 }
 
+// StatMulti returns metadata for each of keys that exists. Missing keys are
+// omitted from the result. The keys are read under the index lock, so the
+// result never mixes entries from before and after another write.
+func (s *CAS) StatMulti(keys []string) map[string]Info {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+	result := make(map[string]Info, len(keys))
+	for _, key := range keys {
+		if info, ok := s.Stat(key); ok {
+			result[key] = info
+		}
+	}
+	return result
+}
+
+// KeysForDigest returns the sorted keys whose content has the given digest.
+func (s *CAS) KeysForDigest(digest Digest) []string {
+	var keys []string
+	for key, info := range s.List("") {
+		if info.Digest == digest {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Delete removes an entry by key.
 func (s *CAS) Delete(key string) {
-	s.entries.Delete(key)
+	if s.readOnly {
+		return
+	}
+	key, err := s.checkKey(key)
+	if err != nil {
+		return
+	}
+	s.deleteEntry(key)
+}
+
+func (s *CAS) deleteEntry(key string) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	s.deleteEntryLocked(key)
+}
+
+func (s *CAS) deleteEntryLocked(key string) {
+	if old, ok := s.entries.LoadAndDelete(key); ok {
+		info := old.(Info)
+		s.metaIndex.update(key, &info, nil)
+		_ = s.audit.record("delete", key, info.Digest, "")
+	}
 	s.dirty.Store(true)
 }
 
+// setEntry stores info at key, keeping secondary indexes in step.
+func (s *CAS) setEntry(key string, info Info) {
+	if old, ok := s.entries.Swap(key, info); ok {
+		prev := old.(Info)
+		s.metaIndex.update(key, &prev, &info)
+	} else {
+		s.metaIndex.update(key, nil, &info)
+	}
+}
+
 // List iterates entries matching prefix.
 func (s *CAS) List(prefix string) iter.Seq2[string, Info] {
 	return func(yield func(string, Info) bool) {
 		s.entries.Range(func(k, v any) bool {
-			key := k.(string)
-			if strings.HasPrefix(key, prefixHashKeyPrefix) {
-				return true // skip internal prefix hashes
-			}
-			if rel, ok := strings.CutPrefix(key, prefix); ok {
+			if rel, ok := strings.CutPrefix(k.(string), prefix); ok {
 				return yield(rel, v.(Info))
 			}
 			return true
@@ -179,13 +600,13 @@ func (s *CAS) List(prefix string) iter.Seq2[string, Info] {
 func (s *CAS) Hash(prefix string) Digest {
 	var items []string
 	s.entries.Range(func(k, v any) bool {
-		key := k.(string)
-		if strings.HasPrefix(key, prefixHashKeyPrefix) {
-			return true // skip internal prefix hashes
-		}
-		if rel, ok := strings.CutPrefix(key, prefix); ok {
+		if rel, ok := strings.CutPrefix(k.(string), prefix); ok {
 			info := v.(Info)
-			items = append(items, fmt.Sprintf("%s\x00%s\x00%d", rel, info.Digest, info.Size))
+			if s.hashIgnoreSize {
+				items = append(items, fmt.Sprintf("%s\x00%s", rel, info.Digest))
+			} else {
+				items = append(items, fmt.Sprintf("%s\x00%s\x00%d", rel, info.Digest, info.Size))
+			}
 		}
 		return true
 	})
@@ -198,16 +619,52 @@ func (s *CAS) Hash(prefix string) Digest {
 	return Digest(digestPrefix + hex.EncodeToString(h[:]))
 }
 
+// LastSyncError returns the error from the most recent index load, Push or
+// Pull (including auto-pull on Open), or nil if it succeeded.
+func (s *CAS) LastSyncError() error {
+	if p := s.syncErr.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+func (s *CAS) setSyncErr(err error) { s.syncErr.Store(&err) }
+
 func (s *CAS) Root() Digest { return s.Hash("") }
 func (s *CAS) Dirty() bool  { return s.dirty.Load() }
-func (s *CAS) Close() error { return s.Sync() }
+func (s *CAS) Close() error { return s.CloseContext(context.Background()) }
+
+// CloseContext stops background work, waits for it to finish and syncs the
+// index. If ctx ends first, in-flight work is cancelled and the index is
+// synced best-effort before returning the context error.
+func (s *CAS) CloseContext(ctx context.Context) error {
+	if s.lazy != nil && !s.ephemeral {
+		defer os.RemoveAll(s.lazy.tmpDir)
+	}
+	if s.origin != nil {
+		s.origin.clones.Delete(s)
+	}
+	s.flushPush()
+	done := make(chan struct{})
+	go func() {
+		s.bg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.bgCancel()
+		return errors.Join(s.Sync(), s.audit.close())
+	case <-ctx.Done():
+		s.bgCancel()
+		return errors.Join(ctx.Err(), s.Sync(), s.audit.close())
+	}
+}
 
 func (s *CAS) Len() int {
 	count := 0
-	s.entries.Range(func(k, _ any) bool {
-		if !strings.HasPrefix(k.(string), prefixHashKeyPrefix) {
-			count++
-		}
+	s.entries.Range(func(_, _ any) bool {
+		count++
 		return true
 	})
 	return count
@@ -221,7 +678,8 @@ func (s *CAS) Ref() string {
 }
 
 func (s *CAS) Exists(key string) bool {
-	if validateKey(key) != nil {
+	key, err := s.checkKey(key)
+	if err != nil || validateKey(key) != nil {
 		return false
 	}
 	_, ok := s.entries.Load(key)
@@ -229,22 +687,29 @@ func (s *CAS) Exists(key string) bool {
 }
 
 func (s *CAS) Clear() {
+	if s.readOnly {
+		return
+	}
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
 	s.entries.Range(func(k, _ any) bool {
 		s.entries.Delete(k)
 		return true
 	})
+	s.metaIndex.reset()
+	s.prefixes.Range(func(k, _ any) bool {
+		s.prefixes.Delete(k)
+		return true
+	})
 	s.dirty.Store(true)
+	_ = s.audit.record("clear", "", "", "")
 }
 
 func (s *CAS) Stats() Stats {
 	var st Stats
 	digests := make(map[Digest]struct{})
 
-	s.entries.Range(func(k, v any) bool {
-		key := k.(string)
-		if strings.HasPrefix(key, prefixHashKeyPrefix) {
-			return true
-		}
+	s.entries.Range(func(_, v any) bool {
 		st.Entries++
 		info := v.(Info)
 		digests[info.Digest] = struct{}{}
@@ -261,11 +726,25 @@ func (s *CAS) Stats() Stats {
 }
 
 func (s *CAS) GC() (int, error) {
+	if s.ephemeral {
+		return 0, ErrClone // the original's blobs aren't in the clone's index
+	}
 	referenced := make(map[string]struct{})
-	s.entries.Range(func(_, v any) bool {
+	keep := func(_, v any) bool {
 		info := v.(Info)
 		hash := strings.TrimPrefix(string(info.Digest), digestPrefix)
 		referenced[hash] = struct{}{}
+		// Keep chunks too, so the next chunked push can reuse them.
+		if list, ok := s.chunks.Load(info.Digest); ok {
+			for _, d := range list.([]Digest) {
+				referenced[strings.TrimPrefix(string(d), digestPrefix)] = struct{}{}
+			}
+		}
+		return true
+	}
+	s.entries.Range(keep)
+	s.clones.Range(func(k, _ any) bool {
+		k.(*CAS).entries.Range(keep)
 		return true
 	})
 
@@ -286,12 +765,100 @@ func (s *CAS) GC() (int, error) {
 	return removed, err
 }
 
+// Verify checks that every blob referenced by the index is present and
+// re-hashes to its digest. It returns the missing or corrupt digests, sorted,
+// so a pulled store can be validated end to end before use.
+func (s *CAS) Verify(ctx context.Context) ([]Digest, error) {
+	referenced := make(map[Digest]struct{})
+	s.entries.Range(func(_, v any) bool {
+		referenced[v.(Info).Digest] = struct{}{}
+		return true
+	})
+
+	var bad []Digest
+	for digest := range referenced {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if data, ok := s.inline.Load(digest); ok {
+			if computeDigest(data.([]byte)) != digest {
+				bad = append(bad, digest)
+			}
+			continue
+		}
+		ok, err := s.blobs.verify(digest)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			bad = append(bad, digest)
+		}
+	}
+	sort.Slice(bad, func(i, j int) bool { return bad[i] < bad[j] })
+	return bad, nil
+}
+
+// ValidateComplete returns the sorted keys whose blobs are absent from the
+// blob store. It only checks presence, without hashing, so it is a cheap
+// gate after a cache restore; use Verify to also catch corrupt content.
+func (s *CAS) ValidateComplete() ([]string, error) {
+	present := make(map[Digest]bool)
+	var missing []string
+	var err error
+	s.entries.Range(func(k, v any) bool {
+		digest := v.(Info).Digest
+		ok, seen := present[digest]
+		if !seen {
+			if ok, err = s.hasBlob(digest); err != nil {
+				return false
+			}
+			present[digest] = ok
+		}
+		if !ok {
+			missing = append(missing, k.(string))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// hasBlob is HasBlob that reports stat failures other than absence.
+func (s *CAS) hasBlob(digest Digest) (bool, error) {
+	if _, ok := s.inline.Load(digest); ok {
+		return true, nil
+	}
+	_, err := os.Stat(s.blobs.blobPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// HasBlob reports whether content with the given digest is stored locally,
+// so callers can skip transferring data the store already has.
+func (s *CAS) HasBlob(digest Digest) bool {
+	if _, ok := s.inline.Load(digest); ok {
+		return true
+	}
+	return s.blobs.Has(digest)
+}
+
 // Path returns the filesystem path for a digest (for advanced use cases).
+// Content stored inline in the index has no file at that path.
 func (s *CAS) Path(digest Digest) string {
 	return s.blobs.blobPath(digest)
 }
 
 func (s *CAS) Sync() error {
+	if s.readOnly || s.ephemeral {
+		return nil
+	}
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
 	if !s.dirty.Load() {
 		return nil
 	}
@@ -301,22 +868,36 @@ func (s *CAS) Sync() error {
 		return fmt.Errorf("create index dir: %w", err)
 	}
 
+	// Clear dirty before taking the snapshot, so a write that races with
+	// the file writes below leaves the store dirty for the next Sync.
+	s.dirty.Store(false)
 	data, err := s.serialize()
 	if err != nil {
+		s.dirty.Store(true)
 		return fmt.Errorf("serialize index: %w", err)
 	}
+	if err := s.writeIndex(indexPath, data); err != nil {
+		s.dirty.Store(true)
+		return err
+	}
+	return nil
+}
 
-	// Atomic write: temp file + rename
-	tmpPath := indexPath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+// writeIndex writes a serialized index and the files that go with it.
+func (s *CAS) writeIndex(indexPath string, data []byte) error {
+	if err := s.rotateIndexBackups(); err != nil {
+		return fmt.Errorf("rotate index backups: %w", err)
+	}
+	if err := writeFileAtomic(indexPath, data, 0644); err != nil {
 		return fmt.Errorf("write index: %w", err)
 	}
-	if err := os.Rename(tmpPath, indexPath); err != nil {
-		_ = os.Remove(tmpPath)
-		return fmt.Errorf("rename index: %w", err)
+	// A second copy to fall back to if the index is later damaged.
+	if err := writeFileAtomic(indexPath+".bak", data, 0644); err != nil {
+		return fmt.Errorf("write index backup: %w", err)
+	}
+	if err := s.writePrefixesFile(); err != nil {
+		return fmt.Errorf("write prefix hashes: %w", err)
 	}
-
-	s.dirty.Store(false)
 	return nil
 }
 
@@ -324,24 +905,48 @@ func (s *CAS) indexPath() string {
 	return filepath.Join(s.cacheDir, s.namespace, s.tag+".json")
 }
 
-// Push uploads to the specified tags.
+// Push uploads to the specified tags. With WithPushDebounce it only
+// schedules the push, as PushAsync does, and returns at once; the outcome is
+// reported by LastSyncError.
 func (s *CAS) Push(ctx context.Context, tags ...string) error {
 	if s.remote == nil {
 		return ErrNoRemote
 	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.debounce != nil {
+		s.PushAsync(tags...)
+		return nil
+	}
 	if len(tags) == 0 {
 		tags = []string{s.remote.Tag()}
 	}
+	return s.push(ctx, tags)
+}
+
+func (s *CAS) push(ctx context.Context, tags []string) error {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
 	for _, tag := range tags {
 		if err := s.pushToTag(ctx, tag); err != nil {
+			s.setSyncErr(err)
 			return err
 		}
 	}
+	s.setSyncErr(nil)
 	return nil
 }
 
 func (s *CAS) pushToTag(ctx context.Context, tag string) error {
-	indexData, err := s.serialize()
+	start := time.Now()
+	if s.chunking {
+		if err := s.chunkPending(); err != nil {
+			return err
+		}
+	}
+
+	indexData, blobs, err := s.snapshot()
 	if err != nil {
 		return fmt.Errorf("serialize index: %w", err)
 	}
@@ -351,166 +956,690 @@ func (s *CAS) pushToTag(ctx context.Context, tag string) error {
 		return fmt.Errorf("store index: %w", err)
 	}
 
-	objects := map[string][]byte{string(indexDigest): indexData}
-	s.blobs.pending.Range(func(k, _ any) bool {
-		digest := k.(Digest)
-		if data, err := s.blobs.Get(digest); err == nil {
-			objects[string(digest)] = data
+	// The index always travels in its own layer; keeping a copy among the
+	// content blobs lets older clients that look for it there still pull.
+	extra := make(map[Digest][]byte)
+	if !s.indexInLayerOnly {
+		extra[indexDigest] = indexData
+	}
+	var treeRoot Digest
+	if s.persistTrees {
+		var trees map[Digest][]byte
+		if treeRoot, trees, err = s.storeTrees(); err != nil {
+			return err
 		}
-		return true
-	})
+		maps.Copy(extra, trees)
+	}
+
+	objects, kept, err := s.pushObjects(blobs, extra)
+	if err != nil {
+		return err
+	}
 
 	r, err := s.remote.WithTag(tag)
 	if err != nil {
 		return fmt.Errorf("invalid tag %q: %w", tag, err)
 	}
 
-	newPrefixes, err := r.Push(ctx, string(indexDigest), objects, s.loadPrefixHashes())
+	res, err := r.Push(ctx, string(indexDigest), string(treeRoot), s.indexFormat(), indexData, objects, kept)
 	if err != nil {
 		return fmt.Errorf("push to %s: %w", tag, err)
 	}
+	if s.verifyAfterPush {
+		// Before any bookkeeping, so a retry uploads everything again.
+		if err := verifyPush(ctx, r, indexDigest, res); err != nil {
+			return fmt.Errorf("verify push to %s: %w", tag, err)
+		}
+	}
+
+	// Only the blobs this push covered leave the pending set: blobs stored
+	// while it ran wait for the next one.
+	s.setPrefixHashes(res.Prefixes)
+	s.blobs.pending.Delete(indexDigest)
+	for digest := range extra {
+		s.blobs.pending.Delete(digest)
+	}
+	for digest := range blobs {
+		s.blobs.pending.Delete(digest)
+	}
+	s.chunks.Range(func(k, _ any) bool {
+		s.blobs.pending.Delete(k)
+		return true
+	})
+	if err := s.appendReflog("push", tag, indexDigest); err != nil {
+		return fmt.Errorf("write ref-log: %w", err)
+	}
+	if err := s.audit.record("push", tag, indexDigest, actorFrom(ctx)); err != nil {
+		return err
+	}
+	s.reportPush(r, start, res)
+
+	if s.mirrorPush {
+		for _, m := range s.fallbacks {
+			if err := s.pushMirror(ctx, m, tag, indexDigest, indexData, treeRoot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pushObjects decides what a push to the primary sends, given the blobs the
+// index needs and extra objects held in memory. A prefix whose blobs hash as
+// recorded is kept as it is on the remote; any other prefix is sent whole,
+// with every blob it holds, since its new layer replaces the old one.
+// Prefixes that hold nothing any more are dropped.
+func (s *CAS) pushObjects(blobs map[Digest]struct{}, extra map[Digest][]byte) (map[string][]byte, map[string]remote.PrefixInfo, error) {
+	sizes := make(map[string]map[string]int64)
+	add := func(digest Digest, size int64) {
+		prefix := remote.PrefixOf(string(digest))
+		if sizes[prefix] == nil {
+			sizes[prefix] = make(map[string]int64)
+		}
+		sizes[prefix][string(digest)] = size
+	}
+	for digest, data := range extra {
+		add(digest, int64(len(data)))
+	}
+	for digest := range blobs {
+		if _, ok := extra[digest]; ok {
+			continue
+		}
+		size, err := s.blobs.Size(digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", digest, err)
+		}
+		add(digest, size)
+	}
+
+	local := s.loadPrefixHashes()
+	objects := make(map[string][]byte)
+	kept := make(map[string]remote.PrefixInfo)
+	for prefix, digests := range sizes {
+		if info, ok := local[prefix]; ok && info.Described() && info.Hash == remote.PrefixHashOfSizes(digests) {
+			kept[prefix] = info
+			continue
+		}
+		for d := range digests {
+			if data, ok := extra[Digest(d)]; ok {
+				objects[d] = data
+				continue
+			}
+			data, err := s.blobs.Get(Digest(d))
+			if err != nil {
+				return nil, nil, fmt.Errorf("read %s: %w", d, err)
+			}
+			objects[d] = data
+		}
+	}
+	return objects, kept, nil
+}
+
+// pushMirror uploads every referenced blob to a fallback remote. Prefix
+// hashes only track the primary, so mirrors always get a full push; layers
+// the mirror already has are skipped by the registry client.
+func (s *CAS) pushMirror(ctx context.Context, mirror *remote.OCIRemote, tag string, indexDigest Digest, indexData []byte, treeRoot Digest) error {
+	start := time.Now()
+	objects := make(map[string][]byte)
+	if !s.indexInLayerOnly {
+		objects[string(indexDigest)] = indexData
+	}
+	if treeRoot != "" {
+		trees := make(map[Digest][]byte)
+		newTreeEncoder(s.concurrency, trees).encode(s.buildTree(""))
+		for digest, data := range trees {
+			objects[string(digest)] = data
+		}
+	}
+	for key, info := range s.List("") {
+		if _, ok := objects[string(info.Digest)]; ok {
+			continue
+		}
+		if _, ok := s.inline.Load(info.Digest); ok {
+			continue // travels in the index
+		}
+		data, err := s.blobs.Get(info.Digest)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", key, err)
+		}
+		objects[string(info.Digest)] = data
+	}
+
+	r, err := mirror.WithTag(tag)
+	if err != nil {
+		return fmt.Errorf("invalid tag %q: %w", tag, err)
+	}
+	res, err := r.Push(ctx, string(indexDigest), string(treeRoot), s.indexFormat(), indexData, objects, nil)
+	if err != nil {
+		return fmt.Errorf("mirror to %s: %w", r, err)
+	}
+	if s.verifyAfterPush {
+		if err := verifyPush(ctx, r, indexDigest, res); err != nil {
+			return fmt.Errorf("verify mirror to %s: %w", r, err)
+		}
+	}
+	s.reportPush(r, start, res)
+	return nil
+}
+
+// verifyPush reads a push back: the manifest must carry the pushed root, and
+// the first new content layer, if any, must be downloadable.
+func verifyPush(ctx context.Context, r *remote.OCIRemote, root Digest, res *remote.PushResult) error {
+	head, err := r.Head(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPushNotPersisted, err)
+	}
+	if head.Root != string(root) {
+		return fmt.Errorf("%w: root is %s, pushed %s", ErrPushNotPersisted, head.Root, root)
+	}
+	if len(res.ChangedPrefixes) > 0 {
+		layer := res.Prefixes[res.ChangedPrefixes[0]].Layer
+		if _, err := r.FetchLayer(ctx, layer); err != nil {
+			return fmt.Errorf("%w: %w", ErrPushNotPersisted, err)
+		}
+	}
+	return nil
+}
+
+// reportPush passes a completed push to the transfer hook, if any. A push
+// doesn't change entries, so the root is the same before and after.
+func (s *CAS) reportPush(r *remote.OCIRemote, start time.Time, res *remote.PushResult) {
+	if s.transferHook == nil {
+		return
+	}
+	root := s.Root()
+	s.transferHook(TransferReport{
+		Op:              "push",
+		Ref:             r.String(),
+		RootBefore:      root,
+		RootAfter:       root,
+		Layers:          res.Layers,
+		Bytes:           res.Bytes,
+		PrefixesChanged: res.ChangedPrefixes,
+		Duration:        time.Since(start),
+	})
+}
+
+// PruneRemoteTags deletes all but the keep most recently pushed CAFS tags
+// from the remote repository. The store's own tag, the one WithRemote points
+// at, is never deleted and doesn't count towards keep. Tags are recognized by
+// the dev.cafs.root label and ordered by their dev.cafs.created label, which
+// Push writes only with WithPushTimestamps; images without it are treated as
+// oldest. If that leaves tags without a push time among the keep newest, it
+// returns ErrNoTimestamps and deletes nothing. Tags pushed at the same time as
+// the oldest kept tag are kept too, since nothing says which is newer.
+func (s *CAS) PruneRemoteTags(ctx context.Context, keep int) error {
+	if s.remote == nil {
+		return ErrNoRemote
+	}
+
+	all, err := s.remote.Tags(ctx)
+	if err != nil {
+		return err
+	}
+	var tags []remote.TagInfo
+	for _, t := range all {
+		if t.Tag != s.remote.Tag() {
+			tags = append(tags, t)
+		}
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if len(tags) <= keep {
+		return nil
+	}
+	if keep > 0 && tags[keep-1].Created.IsZero() {
+		return fmt.Errorf("prune: %w", ErrNoTimestamps)
+	}
 
-	s.savePrefixHashes(newPrefixes)
-	s.blobs.pending = sync.Map{}
+	for _, t := range tags[keep:] {
+		if keep > 0 && t.Created.Equal(tags[keep-1].Created) {
+			continue
+		}
+		if err := s.remote.DeleteTag(ctx, t.Tag); err != nil {
+			return fmt.Errorf("prune %s: %w", t.Tag, err)
+		}
+	}
 	return nil
 }
 
-// Pull downloads from remote.
+// Pull downloads from remote. If the primary remote fails, configured
+// fallbacks are tried in order.
 func (s *CAS) Pull(ctx context.Context) error {
 	if s.remote == nil {
 		return ErrNoRemote
 	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	err := s.pull(ctx, s.remote, true)
+	for _, fb := range s.fallbacks {
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if fbErr := s.pull(ctx, fb, false); fbErr != nil {
+			err = errors.Join(err, fmt.Errorf("fallback %s: %w", fb, fbErr))
+		} else {
+			err = nil
+		}
+	}
+	s.setSyncErr(err)
+	return err
+}
 
-	indexHash, objects, newPrefixes, err := s.remote.Pull(ctx, s.loadPrefixHashes())
+// pull fetches from r. Prefix hashes are only recorded for the primary, since
+// they reference layers in that registry.
+func (s *CAS) pull(ctx context.Context, r *remote.OCIRemote, primary bool) error {
+	start := time.Now()
+	before := s.Root()
+	res, err := r.Pull(ctx, s.loadPrefixHashes())
 	if err != nil {
 		return fmt.Errorf("pull: %w", err)
 	}
 
-	for hash, data := range objects {
+	for hash, data := range res.Objects {
 		if _, err := s.blobs.putWithDigest(normalizeDigest(hash), data); err != nil {
 			return fmt.Errorf("store blob %s: %w", hash, err)
 		}
 	}
 
-	s.savePrefixHashes(newPrefixes)
-
-	indexDigest := normalizeDigest(indexHash)
-	indexData, ok := objects[indexHash]
-	if !ok {
-		var err error
+	indexDigest := normalizeDigest(res.Root)
+	indexData := res.Index
+	if indexData != nil {
+		if _, err := s.blobs.putWithDigest(indexDigest, indexData); err != nil {
+			return fmt.Errorf("store index: %w", err)
+		}
+	} else if data, ok := res.Objects[res.Root]; ok {
+		indexData = data
+	} else {
 		indexData, err = s.blobs.Get(indexDigest)
 		if err != nil {
 			return fmt.Errorf("load index: %w", err)
 		}
 	}
 
-	if err := s.load(indexData); err != nil {
+	incoming, err := s.decodeIndex(indexData)
+	if err != nil {
 		return fmt.Errorf("parse index: %w", err)
 	}
+	for _, info := range incoming {
+		if err := s.reassemble(info.Digest); err != nil {
+			return err
+		}
+	}
+	// Prefix hashes left in the index by older clients describe the pusher's
+	// view of the remote, not ours; other reserved keys are never valid.
+	extractLegacyPrefixes(incoming)
+	s.indexMu.Lock()
+	err = s.merge(incoming, s.pullStrategy)
+	s.indexMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if primary {
+		s.savePrefixHashes(res.Prefixes)
+	}
 
 	s.dirty.Store(true)
 	if err := s.Sync(); err != nil {
 		return fmt.Errorf("sync: %w", err)
 	}
 
-	return nil
-}
-
-func (s *CAS) loadLocalIndex() error {
-	data, err := os.ReadFile(s.indexPath())
-	if err != nil {
+	if err := s.appendReflog("pull", s.tag, indexDigest); err != nil {
+		return fmt.Errorf("write ref-log: %w", err)
+	}
+	if err := s.audit.record("pull", s.tag, indexDigest, actorFrom(ctx)); err != nil {
 		return err
 	}
-	return s.load(data)
+	if s.transferHook != nil {
+		s.transferHook(TransferReport{
+			Op:              "pull",
+			Ref:             r.String(),
+			RootBefore:      before,
+			RootAfter:       s.Root(),
+			Layers:          res.Layers,
+			Bytes:           res.Bytes,
+			PrefixesChanged: res.ChangedPrefixes,
+			Duration:        time.Since(start),
+		})
+	}
+	return nil
 }
 
-const prefixHashKeyPrefix = "_prefix/"
+// merge stores incoming entries, resolving keys that exist locally with a
+// different digest according to strategy.
+func (s *CAS) merge(incoming map[string]Info, strategy string) error {
+	var conflicts []string
+	for key, info := range incoming {
+		if local, ok := s.lookup(key); ok && local.Digest != info.Digest {
+			conflicts = append(conflicts, key)
+		}
+	}
 
-func (s *CAS) loadPrefixHashes() map[string]remote.PrefixInfo {
-	result := make(map[string]remote.PrefixInfo)
-	s.entries.Range(func(k, v any) bool {
-		key := k.(string)
-		if strings.HasPrefix(key, prefixHashKeyPrefix) {
-			prefix := strings.TrimPrefix(key, prefixHashKeyPrefix)
-			info := v.(Info)
-			parts := strings.SplitN(string(info.Digest), "|", 2)
-			if len(parts) == 2 {
-				result[prefix] = remote.PrefixInfo{Hash: parts[0], Layer: parts[1]}
+	if len(conflicts) > 0 {
+		switch strategy {
+		case PullErrorOnConflict:
+			sort.Strings(conflicts)
+			return &ConflictError{Keys: conflicts}
+		case PullOursWins:
+			for _, key := range conflicts {
+				delete(incoming, key)
 			}
 		}
-		return true
-	})
-	return result
+	}
+
+	for key, info := range incoming {
+		s.setEntry(key, info)
+	}
+	return nil
 }
 
-func (s *CAS) savePrefixHashes(prefixes map[string]remote.PrefixInfo) {
-	for prefix, info := range prefixes {
-		key := prefixHashKeyPrefix + prefix
-		s.entries.Store(key, Info{Digest: Digest(info.Hash + "|" + info.Layer)})
+func (s *CAS) loadLocalIndex() error {
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return err
 	}
-	s.dirty.Store(true)
+	if err := s.load(data); err != nil {
+		if rerr := s.recoverIndex(data); rerr != nil {
+			return fmt.Errorf("%w (recovery failed: %w)", err, rerr)
+		}
+	}
+	if err := s.readPrefixesFile(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("load prefix hashes: %w", err)
+	}
+	return nil
 }
 
 // Serialization format
 type serializedInfo struct {
-	Digest string `json:"d"`
-	Size   int64  `json:"s,omitempty"`
-	Meta   any    `json:"m,omitempty"`
+	Digest string   `json:"d"`
+	Size   int64    `json:"s,omitempty"`
+	Meta   any      `json:"m,omitempty"`
+	Inline []byte   `json:"i,omitempty"` // content for blobs stored inline
+	Chunks []Digest `json:"c,omitempty"` // chunk digests for blobs pushed as chunks
 }
 
 func (s *CAS) serialize() ([]byte, error) {
+	data, _, err := s.snapshot()
+	return data, err
+}
+
+// snapshot serializes the index and, from the same view of it, returns the
+// blobs a remote needs to serve it: each entry's blob, or its chunks, and
+// nothing for inline entries.
+func (s *CAS) snapshot() ([]byte, map[Digest]struct{}, error) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
 	m := make(map[string]serializedInfo)
+	blobs := make(map[Digest]struct{})
 	s.entries.Range(func(k, v any) bool {
 		info := v.(Info)
-		m[k.(string)] = serializedInfo{
+		si := serializedInfo{
 			Digest: string(info.Digest),
 			Size:   info.Size,
 			Meta:   info.Meta,
 		}
+		if data, ok := s.inline.Load(info.Digest); ok {
+			si.Inline = data.([]byte)
+		}
+		if list, ok := s.chunks.Load(info.Digest); ok {
+			si.Chunks = list.([]Digest)
+		}
+		switch {
+		case si.Inline != nil || info.Digest == "":
+		case si.Chunks != nil:
+			for _, d := range si.Chunks {
+				blobs[d] = struct{}{}
+			}
+		default:
+			blobs[info.Digest] = struct{}{}
+		}
+		m[k.(string)] = si
+		return true
+	})
+	data, err := json.Marshal(m)
+	return data, blobs, err
+}
+
+// Reopen reloads the on-disk index, picking up changes other processes (such
+// as the cafs CLI) synced to the same cache directory. Without unsynced
+// changes the store is made to match the file exactly. Otherwise the file's
+// entries are merged in using the pull strategy, and keys deleted on disk
+// are kept, since they can't be told apart from keys added here.
+func (s *CAS) Reopen() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	data, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	incoming, err := s.decodeIndex(data)
+	if err != nil {
+		return fmt.Errorf("parse index: %w", err)
+	}
+	s.savePrefixHashes(extractLegacyPrefixes(incoming))
+	if err := s.readPrefixesFile(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("load prefix hashes: %w", err)
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.dirty.Load() {
+		return s.merge(incoming, s.pullStrategy)
+	}
+	s.entries.Range(func(k, v any) bool {
+		if _, ok := incoming[k.(string)]; !ok {
+			info := v.(Info)
+			s.entries.Delete(k)
+			s.metaIndex.update(k.(string), &info, nil)
+		}
 		return true
 	})
-	return json.Marshal(m)
+	for key, info := range incoming {
+		s.setEntry(key, info)
+	}
+	return nil
+}
+
+// recoverIndex loads what it can of a damaged index: a complete index
+// followed by junk, as left by a torn append, loads up to the junk; anything
+// else falls back to the backups Sync keeps, newest first. The store is
+// marked dirty so the next Sync replaces the damaged file.
+func (s *CAS) recoverIndex(data []byte) error {
+	var raw json.RawMessage
+	if json.NewDecoder(bytes.NewReader(data)).Decode(&raw) == nil && s.load(raw) == nil {
+		s.dirty.Store(true)
+		return nil
+	}
+	candidates := []string{s.indexPath() + ".bak"}
+	for n := 1; n <= s.indexBackups; n++ {
+		candidates = append(candidates, fmt.Sprintf("%s.%d", s.indexPath(), n))
+	}
+	// Missing backups are skipped rather than reported: a not-exist error
+	// would make the damaged index look absent to Open.
+	var errs []error
+	for _, path := range candidates {
+		bak, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = s.load(bak)
+		}
+		if err == nil {
+			s.dirty.Store(true)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no backup to recover from")
+	}
+	return errors.Join(errs...)
+}
+
+// rotateIndexBackups shifts tag.json.1..N-1 up by one and makes the current
+// index tag.json.1, keeping the last N indexes set with WithIndexBackups.
+// The current index is hard-linked rather than moved, so it stays in place
+// until Sync atomically replaces it.
+func (s *CAS) rotateIndexBackups() error {
+	if s.indexBackups <= 0 {
+		return nil
+	}
+	indexPath := s.indexPath()
+	if _, err := os.Stat(indexPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", indexPath, n) }
+
+	for n := s.indexBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	_ = os.Remove(backup(1))
+	if err := os.Link(indexPath, backup(1)); err != nil {
+		data, err := os.ReadFile(indexPath)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(backup(1), data, 0644)
+	}
+	return nil
 }
 
 func (s *CAS) load(data []byte) error {
+	m, err := s.decodeIndex(data)
+	if err != nil {
+		return err
+	}
+	s.savePrefixHashes(extractLegacyPrefixes(m))
+	for k, info := range m {
+		s.setEntry(k, info)
+	}
+	return nil
+}
+
+// decodeIndex parses serialized entries. Inline content is verified against
+// its digest and kept in s.inline; since it is content-addressed this is
+// harmless even if the caller then discards some entries.
+func (s *CAS) decodeIndex(data []byte) (map[string]Info, error) {
 	var m map[string]serializedInfo
 	if err := json.Unmarshal(data, &m); err != nil {
-		return err
+		return nil, err
 	}
+	result := make(map[string]Info, len(m))
 	for k, v := range m {
-		s.entries.Store(k, Info{
+		result[k] = Info{
 			Digest: Digest(v.Digest),
 			Size:   v.Size,
 			Meta:   v.Meta,
-		})
+		}
+		if v.Inline != nil && computeDigest(v.Inline) == Digest(v.Digest) {
+			s.inline.Store(Digest(v.Digest), v.Inline)
+		}
+		if len(v.Chunks) > 0 && validChunks(v.Chunks) {
+			s.chunks.Store(Digest(v.Digest), v.Chunks)
+		}
 	}
-	return nil
+	return result, nil
 }
 
 // blobStore handles content-addressed blob storage
 type blobStore struct {
 	dir     string
-	pending sync.Map
+	pending *sync.Map // blobs the next push uploads
+	staged  *sync.Map // blobs written but not yet referenced, left out of pending
+}
+
+func newBlobStore(dir string) *blobStore {
+	return &blobStore{dir: dir, pending: new(sync.Map), staged: new(sync.Map)}
 }
 
 func (b *blobStore) Put(data []byte) (Digest, error) {
-	h := sha256.Sum256(data)
-	digest := Digest(digestPrefix + hex.EncodeToString(h[:]))
+	digest := computeDigest(data)
 	isNew, err := b.putWithDigest(digest, data)
 	if err != nil {
 		return "", err
 	}
 	if isNew {
 		b.pending.Store(digest, struct{}{})
+	} else {
+		b.queue(digest)
 	}
 	return digest, nil
 }
 
-func (b *blobStore) putWithDigest(digest Digest, data []byte) (isNew bool, err error) {
+// stage writes a blob without queuing it for push, for content that may
+// never be referenced, such as a transaction's before Commit.
+func (b *blobStore) stage(data []byte) (Digest, error) {
+	digest := computeDigest(data)
+	isNew, err := b.putWithDigest(digest, data)
+	if err != nil {
+		return "", err
+	}
+	if isNew {
+		b.staged.Store(digest, struct{}{})
+	}
+	return digest, nil
+}
+
+// queue moves a staged blob into the pending set, once something refers to
+// it. Blobs that were never staged are left alone.
+func (b *blobStore) queue(digest Digest) {
+	if _, ok := b.staged.LoadAndDelete(digest); ok {
+		b.pending.Store(digest, struct{}{})
+	}
+}
+
+// PutReader streams r into the store, hashing it on the way so the content
+// never needs to be held in memory.
+func (b *blobStore) PutReader(r io.Reader) (Digest, int64, error) {
+	// Temp files live beside the blob dir so GC doesn't see them and the
+	// final rename stays on one filesystem.
+	tmp, err := os.CreateTemp(filepath.Dir(b.dir), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	digest, size, err := computeDigestReader(io.TeeReader(r, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if b.Has(digest) {
+		b.queue(digest)
+		return digest, size, nil
+	}
 	path := b.blobPath(digest)
-	if _, err := os.Stat(path); err == nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	b.pending.Store(digest, struct{}{})
+	return digest, size, nil
+}
+
+func (b *blobStore) putWithDigest(digest Digest, data []byte) (isNew bool, err error) {
+	if b.Has(digest) {
 		return false, nil
 	}
+	path := b.blobPath(digest)
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return false, err
@@ -518,6 +1647,39 @@ func (b *blobStore) putWithDigest(digest Digest, data []byte) (isNew bool, err e
 	return true, os.WriteFile(path, data, 0644)
 }
 
+// Has reports whether a blob with the given digest is on disk.
+func (b *blobStore) Has(digest Digest) bool {
+	_, err := os.Stat(b.blobPath(digest))
+	return err == nil
+}
+
+// verify reports whether the blob exists and its content matches digest.
+func (b *blobStore) verify(digest Digest) (bool, error) {
+	f, err := os.Open(b.blobPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	got, _, err := computeDigestReader(f)
+	if err != nil {
+		return false, err
+	}
+	return got == digest, nil
+}
+
+// Size returns the size of a blob on disk.
+func (b *blobStore) Size(digest Digest) (int64, error) {
+	fi, err := os.Stat(b.blobPath(digest))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
 func (b *blobStore) Get(digest Digest) ([]byte, error) {
 	return os.ReadFile(b.blobPath(digest))
 }
@@ -530,6 +1692,35 @@ func (b *blobStore) blobPath(digest Digest) string {
 	return filepath.Join(b.dir, hash[:2], hash[2:])
 }
 
+// DigestOf returns the digest data would be stored under, without storing
+// it, so a caller can check HasBlob before transferring content.
+func DigestOf(data []byte) Digest {
+	return computeDigest(data)
+}
+
+// DigestOfReader is DigestOf for a stream. It also returns the number of
+// bytes read.
+func DigestOfReader(r io.Reader) (Digest, int64, error) {
+	return computeDigestReader(r)
+}
+
+// computeDigest is the store's one content hash: SHA-256 over the raw bytes,
+// with no framing, so digests match sha256sum and OCI blob digests.
+func computeDigest(data []byte) Digest {
+	h := sha256.Sum256(data)
+	return Digest(digestPrefix + hex.EncodeToString(h[:]))
+}
+
+// computeDigestReader hashes r with the same scheme as computeDigest.
+func computeDigestReader(r io.Reader) (Digest, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return Digest(digestPrefix + hex.EncodeToString(h.Sum(nil))), n, nil
+}
+
 func expandPath(path string) string {
 	if strings.HasPrefix(path, "~/") {
 		if home, err := os.UserHomeDir(); err == nil {
@@ -0,0 +1,155 @@
+package cafs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+// Large blobs can be pushed as content-defined chunks (WithChunking), so a
+// small edit to a big file only uploads the chunks around the edit. The index
+// records each chunked blob's chunk digests ("c"), and pull reassembles the
+// blob from them. Chunks are ordinary blobs and share the usual layers.
+const (
+	chunkThreshold = 2 << 20   // blobs larger than this are chunked
+	chunkMin       = 128 << 10 // no boundary before this many bytes
+	chunkMax       = 2 << 20   // forced boundary
+	chunkMask      = 1<<19 - 1 // ~512KiB average past chunkMin
+)
+
+// gearTable drives the rolling hash. It must never change: boundaries, and
+// with them chunk digests, depend on it.
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// splitChunks cuts data at content-defined boundaries, so an insertion only
+// moves the boundaries next to it.
+func splitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunkMin {
+			n = min(n, chunkMax)
+			var h uint64
+			for i := chunkMin; i < n; i++ {
+				h = h<<1 + gearTable[data[i]]
+				if h&chunkMask == 0 {
+					n = i + 1
+					break
+				}
+			}
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// chunkBlob splits a large blob into chunk blobs, marking new ones for upload,
+// and records the chunk list. It returns false for blobs too small to chunk.
+func (s *CAS) chunkBlob(digest Digest) (bool, error) {
+	if _, ok := s.chunks.Load(digest); ok {
+		return true, nil
+	}
+	data, err := s.blobs.Get(digest)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil // gone since it was staged; Push skips it too
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(data) <= chunkThreshold {
+		return false, nil
+	}
+
+	var list []Digest
+	for _, chunk := range splitChunks(data) {
+		d := computeDigest(chunk)
+		isNew, err := s.blobs.putWithDigest(d, chunk)
+		if err != nil {
+			return false, err
+		}
+		if isNew {
+			s.blobs.pending.Store(d, struct{}{})
+		}
+		list = append(list, d)
+	}
+	s.chunks.Store(digest, list)
+	return true, nil
+}
+
+// reassemble rebuilds a chunked blob from local chunks. It does nothing if
+// the blob is present, isn't chunked or a chunk is missing, leaving Get to
+// report the missing content as it would for any other blob.
+func (s *CAS) reassemble(digest Digest) error {
+	v, ok := s.chunks.Load(digest)
+	if !ok || s.blobs.Has(digest) {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, d := range v.([]Digest) {
+		chunk, err := s.blobs.Get(d)
+		if err != nil {
+			return nil
+		}
+		buf.Write(chunk)
+	}
+	if computeDigest(buf.Bytes()) != digest {
+		return fmt.Errorf("reassemble %s: chunks don't match digest", digest)
+	}
+	_, err := s.blobs.putWithDigest(digest, buf.Bytes())
+	return err
+}
+
+// chunkPending chunks every large blob waiting to be pushed.
+func (s *CAS) chunkPending() error {
+	var digests []Digest
+	s.blobs.pending.Range(func(k, _ any) bool {
+		digests = append(digests, k.(Digest))
+		return true
+	})
+	for _, digest := range digests {
+		if _, err := s.chunkBlob(digest); err != nil {
+			return fmt.Errorf("chunk %s: %w", digest, err)
+		}
+	}
+	return nil
+}
+
+// indexFormat is the remote format the index needs: FormatChunked once any
+// entry is recorded as chunks, since older readers would miss those blobs.
+func (s *CAS) indexFormat() int {
+	format := remote.FormatPlain
+	s.entries.Range(func(_, v any) bool {
+		if _, ok := s.chunks.Load(v.(Info).Digest); ok {
+			format = remote.FormatChunked
+			return false
+		}
+		return true
+	})
+	return format
+}
+
+// validChunks rejects chunk lists from an index that aren't well-formed
+// digests, since digests become blob paths.
+func validChunks(list []Digest) bool {
+	for _, d := range list {
+		if !isDigest(string(d)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/aweris/cafs/internal/remote"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func randomBytes(n int, seed uint64) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+func TestSplitChunksLocalizesEdits(t *testing.T) {
+	data := randomBytes(8<<20, 1)
+	edited := bytes.Clone(data)
+	edited = append(edited[:4<<20:4<<20], append([]byte("inserted"), edited[4<<20:]...)...)
+
+	chunks := splitChunks(data)
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatal("chunks don't reassemble to the input")
+	}
+	seen := make(map[Digest]bool)
+	for _, c := range chunks {
+		if len(c) > chunkMax {
+			t.Errorf("chunk of %d bytes exceeds the maximum", len(c))
+		}
+		seen[computeDigest(c)] = true
+	}
+	changed := 0
+	for _, c := range splitChunks(edited) {
+		if !seen[computeDigest(c)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("an 8 byte insertion changed %d of %d chunks", changed, len(chunks))
+	}
+}
+
+func imageFormat(t *testing.T, ref string) string {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := ggcrremote.Image(r)
+	if err != nil {
+		t.Fatalf("fetch %s: %v", ref, err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Config.Labels["dev.cafs.format"]
+}
+
+func TestChunkedPushUploadsOnlyChangedChunks(t *testing.T) {
+	const size = 8 << 20
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	var reports []TransferReport
+	s := newTestStore(t, WithRemote(ref), WithChunking(), WithTransferHook(func(r TransferReport) {
+		reports = append(reports, r)
+	}))
+	data := randomBytes(size, 2)
+	mustPut(t, s, "big.bin", string(data))
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got := imageFormat(t, ref); got != strconv.Itoa(remote.FormatChunked) {
+		t.Errorf("format label = %q, want %d", got, remote.FormatChunked)
+	}
+	reader := newTestStore(t, WithRemote(ref))
+	if err := reader.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+
+	copy(data[size/2:], "a small edit")
+	mustPut(t, s, "big.bin", string(data))
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("second Push: %v", err)
+	}
+	if first, second := reports[0].Bytes, reports[1].Bytes; first < size || second > size/4 {
+		t.Errorf("pushes uploaded %d then %d bytes; want the whole file then a few chunks", first, second)
+	}
+
+	// The reader fetches the changed chunks and reassembles the rest.
+	if err := reader.Pull(ctx); err != nil {
+		t.Fatalf("second Pull: %v", err)
+	}
+	if got := mustGet(t, reader, "big.bin"); got != string(data) {
+		t.Error("pulled content differs from the edited file")
+	}
+}
+
+func TestPlainPushKeepsPlainFormat(t *testing.T) {
+	ref := newTestRegistry(t) + "/repo:main"
+	s := newTestStore(t, WithRemote(ref), WithChunking())
+	mustPut(t, s, "small.txt", "below the chunking threshold")
+	if err := s.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got := imageFormat(t, ref); got != strconv.Itoa(remote.FormatPlain) {
+		t.Errorf("format label = %q, want %d", got, remote.FormatPlain)
+	}
+}
+
+func TestPullRejectsNewerFormat(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+	s := newTestStore(t, WithRemote(ref))
+	index := []byte("{}")
+	root := string(computeDigest(index))
+	if _, err := s.remote.Push(ctx, root, "", remote.MaxFormat+1, index, nil, nil); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	fresh := newTestStore(t, WithRemote(ref))
+	if err := fresh.Pull(ctx); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Pull: err = %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,123 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Clone returns an in-memory copy of the store for speculative changes. It
+// shares the blob files, which are immutable content, but has its own entry
+// index, so Puts and Deletes on the clone don't affect s until adopted with
+// MergeFrom, while s itself stays writable; blobs the clone writes are pushed by s only once it adopts
+// entries that use them. The clone is never synced to disk and has no
+// remote; closing it discards it. GC on the clone fails with ErrClone, and GC
+// on s keeps the blobs of clones that are still open.
+func (s *CAS) Clone() Store {
+	c := &CAS{
+		// Blobs the clone adds wait in s's staged set, out of its pushes.
+		blobs:     &blobStore{dir: s.blobs.dir, pending: s.blobs.staged, staged: s.blobs.staged},
+		origin:    s,
+		metaIndex: newMetaIndex(s.metaIndex.fieldNames()),
+		namespace: s.namespace,
+		tag:       s.tag,
+		cacheDir:  s.cacheDir,
+		ephemeral: true,
+
+		indexInLayerOnly: s.indexInLayerOnly,
+		persistTrees:     s.persistTrees,
+		pullStrategy:     s.pullStrategy,
+		inlineThreshold:  s.inlineThreshold,
+		concurrency:      s.concurrency,
+		hashIgnoreSize:   s.hashIgnoreSize,
+		keyValidator:     s.keyValidator,
+		keyNormalizer:    s.keyNormalizer,
+		lazy:             s.lazy,
+		base:             make(map[string]Info),
+	}
+	c.bgCtx, c.bgCancel = context.WithCancel(context.Background())
+
+	s.inline.Range(func(k, v any) bool {
+		c.inline.Store(k, v)
+		return true
+	})
+	s.chunks.Range(func(k, v any) bool {
+		c.chunks.Store(k, v)
+		return true
+	})
+	s.indexMu.RLock()
+	for key, info := range s.List("") {
+		c.setEntry(key, info)
+		c.base[key] = info
+	}
+	s.indexMu.RUnlock()
+	s.clones.Store(c, struct{}{})
+	return c
+}
+
+// MergeFrom adopts the changes made in clone, a store returned by s.Clone,
+// since it was cloned or last merged: keys it added or changed are stored and
+// keys it deleted are removed. Keys s changed in the meantime are left alone.
+// If s and the clone changed the same key differently, MergeFrom returns a
+// *ConflictError listing those keys and adopts nothing.
+func (s *CAS) MergeFrom(clone Store) error {
+	c, ok := clone.(*CAS)
+	if !ok || c.origin != s {
+		return errors.New("cafs: MergeFrom needs a clone of this store")
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	// The clone's changes: its entry, or nil for a key it deleted.
+	current := make(map[string]Info)
+	changes := make(map[string]*Info)
+	for key, info := range c.List("") {
+		current[key] = info
+		if old, ok := c.base[key]; !ok || !sameEntry(old, info) {
+			changes[key] = &info
+		}
+	}
+	for key := range c.base {
+		if _, ok := current[key]; !ok {
+			changes[key] = nil
+		}
+	}
+
+	var conflicts []string
+	for key, change := range changes {
+		old, had := c.base[key]
+		cur, has := s.lookup(key)
+		switch {
+		case has == had && (!has || sameEntry(cur, old)): // untouched in s
+		case change == nil && !has, change != nil && has && sameEntry(cur, *change):
+			delete(changes, key) // s made the same change
+		default:
+			conflicts = append(conflicts, key)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return &ConflictError{Keys: conflicts}
+	}
+
+	c.inline.Range(func(k, v any) bool {
+		s.inline.Store(k, v)
+		return true
+	})
+	for key, change := range changes {
+		if change == nil {
+			s.deleteEntryLocked(key)
+			continue
+		}
+		s.blobs.queue(change.Digest)
+		if err := s.storeEntryLocked(key, *change); err != nil {
+			return err
+		}
+	}
+	c.base = current
+	return nil
+}
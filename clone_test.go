@@ -0,0 +1,159 @@
+package cafs
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func isPending(s *CAS, digest Digest) bool {
+	_, ok := s.blobs.pending.Load(digest)
+	return ok
+}
+
+func TestCloneAndMergeFrom(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "original a")
+	mustPut(t, s, "b", "original b")
+
+	c := s.Clone()
+	t.Cleanup(func() { _ = c.Close() })
+	mustPut(t, c, "a", "changed a")
+	mustPut(t, c, "d", "new d")
+	c.Delete("b")
+
+	if got := mustGet(t, s, "a"); got != "original a" {
+		t.Errorf("original a = %q after changing the clone", got)
+	}
+	if _, ok := s.Stat("b"); !ok {
+		t.Error("deleting from the clone deleted from the original")
+	}
+	if _, ok := s.Stat("d"); ok {
+		t.Error("clone's new key visible in the original")
+	}
+	d, _ := c.Stat("d")
+	if isPending(s, d.Digest) {
+		t.Error("clone's blob queued for push before merging")
+	}
+
+	if err := s.MergeFrom(c); err != nil {
+		t.Fatalf("MergeFrom: %v", err)
+	}
+	if got := mustGet(t, s, "a"); got != "changed a" {
+		t.Errorf("a = %q after merge", got)
+	}
+	if got := mustGet(t, s, "d"); got != "new d" {
+		t.Errorf("d = %q after merge", got)
+	}
+	if _, ok := s.Stat("b"); ok {
+		t.Error("b survived the merge")
+	}
+	if !isPending(s, d.Digest) {
+		t.Error("merged blob not queued for push")
+	}
+
+	other := newTestStore(t)
+	if err := s.MergeFrom(other); err == nil {
+		t.Error("MergeFrom accepted a store that isn't a clone")
+	}
+	if err := other.MergeFrom(c); err == nil {
+		t.Error("MergeFrom accepted another store's clone")
+	}
+}
+
+func TestMergeFromKeepsOriginChanges(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "original a")
+	mustPut(t, s, "b", "original b")
+	mustPut(t, s, "c", "original c")
+	mustPut(t, s, "same", "original")
+
+	c := s.Clone()
+	t.Cleanup(func() { _ = c.Close() })
+	mustPut(t, c, "a", "clone a")
+	mustPut(t, c, "same", "both")
+
+	// The origin moves on independently of the clone.
+	mustPut(t, s, "b", "origin b")
+	s.Delete("c")
+	mustPut(t, s, "new", "origin new")
+	mustPut(t, s, "same", "both")
+
+	if err := s.MergeFrom(c); err != nil {
+		t.Fatalf("MergeFrom: %v", err)
+	}
+	for key, want := range map[string]string{"a": "clone a", "b": "origin b", "new": "origin new", "same": "both"} {
+		if got := mustGet(t, s, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := s.Stat("c"); ok {
+		t.Error("merge restored a key the origin deleted")
+	}
+
+	// Later merges only carry what changed since the last one.
+	mustPut(t, s, "a", "origin a")
+	if err := s.MergeFrom(c); err != nil {
+		t.Fatalf("second MergeFrom: %v", err)
+	}
+	if got := mustGet(t, s, "a"); got != "origin a" {
+		t.Errorf("a = %q after merging an unchanged clone, want %q", got, "origin a")
+	}
+}
+
+func TestMergeFromConflicts(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "original a")
+	mustPut(t, s, "b", "original b")
+
+	c := s.Clone()
+	t.Cleanup(func() { _ = c.Close() })
+	mustPut(t, c, "a", "clone a")
+	c.Delete("b")
+	mustPut(t, c, "d", "clone d")
+
+	mustPut(t, s, "a", "origin a")
+	mustPut(t, s, "b", "origin b")
+
+	err := s.MergeFrom(c)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("MergeFrom = %v, want a ConflictError", err)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(conflict.Keys, want) {
+		t.Errorf("conflict keys = %v, want %v", conflict.Keys, want)
+	}
+	if got := mustGet(t, s, "a"); got != "origin a" {
+		t.Errorf("a = %q after a failed merge", got)
+	}
+	if _, ok := s.Stat("d"); ok {
+		t.Error("failed merge adopted the clone's other changes")
+	}
+}
+
+func TestCloneGC(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "a")
+
+	c := s.Clone()
+	mustPut(t, c, "only-in-clone", "clone content")
+	info, _ := c.Stat("only-in-clone")
+
+	if _, err := c.(*CAS).GC(); !errors.Is(err, ErrClone) {
+		t.Errorf("GC on a clone: err = %v, want ErrClone", err)
+	}
+	if _, err := s.GC(); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if _, err := os.Stat(s.Path(info.Digest)); err != nil {
+		t.Errorf("GC removed a blob an open clone uses: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := s.GC(); err != nil || removed != 1 {
+		t.Errorf("GC after closing the clone = %d, %v; want 1 removed", removed, err)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aweris/cafs"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <ref> [prefix]",
+	Short: "Write entries to stdout as a record stream",
+	Long: `Write entries of a namespace to stdout, optionally filtered by prefix.
+
+Each entry is a [uint32 key length][key][uint64 size][content] record
+(big-endian), in key order. Read it back with "cafs import".`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runExport,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <ref>",
+	Short: "Read entries from a record stream on stdin",
+	Long:  `Store every entry of a stream written by "cafs export", read from stdin.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) (err error) {
+	ref := args[0]
+	prefix := ""
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+
+	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return fs.StreamOut(os.Stdout, prefix)
+}
+
+func runImport(cmd *cobra.Command, args []string) (err error) {
+	ref := args[0]
+
+	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	n, err := fs.StreamIn(os.Stdin)
+	fmt.Fprintf(os.Stderr, "Imported %d entries\n", n)
+	return err
+}
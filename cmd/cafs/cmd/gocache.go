@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/aweris/cafs"
+	"github.com/aweris/cafs/gocache"
+	"github.com/spf13/cobra"
+)
+
+var gocacheCmd = &cobra.Command{
+	Use:   "gocache [ref]",
+	Short: "Serve the Go build cache over GOCACHEPROG",
+	Long: `Serve the Go build cache from a namespace over the GOCACHEPROG protocol
+on stdin/stdout. cmd/go starts it itself:
+
+  GOCACHEPROG="cafs gocache ttl.sh/myorg/gocache:main" go build ./...
+
+The ref defaults to gocache/default:main. Builds stay local; use
+"cafs pull" and "cafs push" around them to share the cache.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runGocache,
+}
+
+func init() {
+	rootCmd.AddCommand(gocacheCmd)
+}
+
+func runGocache(cmd *cobra.Command, args []string) (err error) {
+	ref := "gocache/default:main"
+	if len(args) > 0 {
+		ref = args[0]
+	}
+
+	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return gocache.NewHandler(fs).Serve(os.Stdin, os.Stdout)
+}
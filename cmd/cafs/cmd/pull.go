@@ -18,13 +18,21 @@ var pullCmd = &cobra.Command{
 }
 
 func init() {
+	pullCmd.Flags().String("report", "", "write a JSON report of the transfer to this path")
 	rootCmd.AddCommand(pullCmd)
 }
 
 func runPull(cmd *cobra.Command, args []string) (err error) {
 	ref := args[0]
 
-	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	reportPath, _ := cmd.Flags().GetString("report")
+	var report transferLog
+
+	fs, err := cafs.Open(ref,
+		cafs.WithCacheDir(getCacheDir()),
+		cafs.WithRemote(ref),
+		cafs.WithTransferHook(report.add),
+	)
 	if err != nil {
 		return err
 	}
@@ -39,6 +47,9 @@ func runPull(cmd *cobra.Command, args []string) (err error) {
 	if err := fs.Pull(context.Background()); err != nil {
 		return fmt.Errorf("pull failed: %w", err)
 	}
+	if err := report.write(reportPath); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
 
 	fmt.Fprintf(os.Stderr, "Done. Root: %s\n", fs.Root())
 	return nil
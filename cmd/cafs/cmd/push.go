@@ -18,6 +18,7 @@ var pushCmd = &cobra.Command{
 }
 
 func init() {
+	pushCmd.Flags().String("report", "", "write a JSON report of the transfer to this path")
 	rootCmd.AddCommand(pushCmd)
 }
 
@@ -25,7 +26,14 @@ func runPush(cmd *cobra.Command, args []string) (err error) {
 	ref := args[0]
 	tags := args[1:]
 
-	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	reportPath, _ := cmd.Flags().GetString("report")
+	var report transferLog
+
+	fs, err := cafs.Open(ref,
+		cafs.WithCacheDir(getCacheDir()),
+		cafs.WithRemote(ref),
+		cafs.WithTransferHook(report.add),
+	)
 	if err != nil {
 		return err
 	}
@@ -40,6 +48,9 @@ func runPush(cmd *cobra.Command, args []string) (err error) {
 	if err := fs.Push(context.Background(), tags...); err != nil {
 		return fmt.Errorf("push failed: %w", err)
 	}
+	if err := report.write(reportPath); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
 
 	fmt.Fprintf(os.Stderr, "Done. Root: %s\n", fs.Root())
 	return nil
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/aweris/cafs"
+)
+
+// transferLog collects transfer reports for --report.
+type transferLog struct {
+	Transfers []cafs.TransferReport `json:"transfers"`
+}
+
+func (l *transferLog) add(r cafs.TransferReport) {
+	l.Transfers = append(l.Transfers, r)
+}
+
+// write saves the log as JSON to path; an empty path is a no-op.
+func (l *transferLog) write(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
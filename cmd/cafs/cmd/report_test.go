@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aweris/cafs"
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func readReport(t *testing.T, path string) cafs.TransferReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var l transferLog
+	if err := json.Unmarshal(data, &l); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if len(l.Transfers) != 1 {
+		t.Fatalf("report has %d transfers, want 1", len(l.Transfers))
+	}
+	return l.Transfers[0]
+}
+
+func TestPushAndPullReports(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/repo:main"
+
+	src := t.TempDir()
+	fs, err := cafs.Open(ref, cafs.WithCacheDir(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put("a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	root := fs.Root()
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	rootCmd.SetArgs([]string{"--cache-dir", src, "push", "--report", filepath.Join(out, "push.json"), ref})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	push := readReport(t, filepath.Join(out, "push.json"))
+	if push.Op != "push" || push.RootAfter != root || push.Layers == 0 || push.Bytes == 0 || len(push.PrefixesChanged) == 0 {
+		t.Errorf("push report = %+v", push)
+	}
+
+	rootCmd.SetArgs([]string{"--cache-dir", t.TempDir(), "pull", "--report", filepath.Join(out, "pull.json"), ref})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	pull := readReport(t, filepath.Join(out, "pull.json"))
+	if pull.Op != "pull" || pull.RootBefore != "" || pull.RootAfter != root || pull.Layers == 0 {
+		t.Errorf("pull report = %+v", pull)
+	}
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/aweris/cafs"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve <ref>",
+	Short: "Serve a namespace over HTTP",
+	Long: `Serve entries of a namespace over HTTP.
+
+  GET /<key>     entry content
+  GET /healthz   liveness check
+  GET /metrics   Prometheus metrics
+
+With --allow-write and --token, clients presenting the token as a bearer
+credential may also:
+
+  PUT /<key>     store the request body at key
+  DELETE /<key>  remove key
+
+Each write is synced to the local index before it is acknowledged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().String("addr", ":8080", "listen address")
+	serveCmd.Flags().Bool("allow-write", false, "enable PUT and DELETE")
+	serveCmd.Flags().String("token", "", "bearer token required for writes (env: CAFS_SERVE_TOKEN)")
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) (err error) {
+	ref := args[0]
+	addr, _ := cmd.Flags().GetString("addr")
+	allowWrite, _ := cmd.Flags().GetBool("allow-write")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("CAFS_SERVE_TOKEN")
+	}
+	if allowWrite && token == "" {
+		return fmt.Errorf("--allow-write requires --token")
+	}
+
+	fs, err := cafs.Open(ref, cafs.WithCacheDir(getCacheDir()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newServer(fs, allowWrite, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+	fmt.Fprintf(os.Stderr, "Serving %s on %s\n", ref, addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintf(os.Stderr, "Shutting down...\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// server serves store content and operational endpoints.
+type server struct {
+	fs         cafs.Store
+	allowWrite bool
+	token      string
+
+	requests    atomic.Int64
+	hits        atomic.Int64
+	misses      atomic.Int64
+	errors      atomic.Int64
+	bytesServed atomic.Int64
+
+	// Blob gauges stat every blob, so scrapes reuse them for statsTTL.
+	statsMu sync.Mutex
+	stats   cafs.Stats
+	statsAt time.Time
+}
+
+const statsTTL = time.Minute
+
+func newServer(fs cafs.Store, allowWrite bool, token string) *server {
+	return &server{fs: fs, allowWrite: allowWrite, token: token}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	switch r.URL.Path {
+	case "/healthz":
+		s.handleHealth(w, r)
+		return
+	case "/metrics":
+		s.handleMetrics(w, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.handleGet(w, r, key)
+	case http.MethodPut, http.MethodDelete:
+		if !s.allowWrite {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cafs"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPut {
+			s.handlePut(w, r, key)
+		} else {
+			s.handleDelete(w, r, key)
+		}
+	default:
+		if s.allowWrite {
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) authorized(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1
+}
+
+func (s *server) handlePut(w http.ResponseWriter, r *http.Request, key string) {
+	if err := s.fs.PutReader(key, r.Body); err != nil {
+		if errors.Is(err, cafs.ErrInvalidKey) || errors.Is(err, cafs.ErrReservedKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.errors.Add(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.fs.Sync(); err != nil {
+		s.errors.Add(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, _ := s.fs.Stat(key)
+	w.Header().Set("ETag", strconv.Quote(string(info.Digest)))
+	w.Header().Set("X-Cafs-Root", string(s.fs.Root()))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
+	if !s.fs.Exists(key) {
+		http.NotFound(w, r)
+		return
+	}
+	s.fs.Delete(key)
+	if err := s.fs.Sync(); err != nil {
+		s.errors.Add(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Cafs-Root", string(s.fs.Root()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) handleGet(w http.ResponseWriter, r *http.Request, key string) {
+	info, ok := s.fs.Stat(key)
+	if !ok {
+		s.misses.Add(1)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", strconv.Quote(string(info.Digest)))
+	if r.Method == http.MethodHead {
+		s.hits.Add(1)
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		return
+	}
+
+	data, err := s.fs.Get(key)
+	if err != nil {
+		s.errors.Add(1)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.hits.Add(1)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	n, _ := w.Write(data)
+	s.bytesServed.Add(int64(n))
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	st := s.blobStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "cafs_http_requests_total", "counter", "HTTP requests received.", s.requests.Load())
+	writeMetric(w, "cafs_cache_hits_total", "counter", "Lookups that found the key.", s.hits.Load())
+	writeMetric(w, "cafs_cache_misses_total", "counter", "Lookups for missing keys.", s.misses.Load())
+	writeMetric(w, "cafs_errors_total", "counter", "Requests that failed with a server error.", s.errors.Load())
+	writeMetric(w, "cafs_bytes_served_total", "counter", "Content bytes written to clients.", s.bytesServed.Load())
+	writeMetric(w, "cafs_entries", "gauge", "Entries in the index.", int64(s.fs.Len()))
+	writeMetric(w, "cafs_blobs", "gauge", "Unique blobs on disk, refreshed every minute.", int64(st.Blobs))
+	writeMetric(w, "cafs_blob_bytes", "gauge", "Total size of blobs on disk, refreshed every minute.", st.TotalSize)
+}
+
+// blobStats returns the store's Stats, recomputed at most once per statsTTL.
+func (s *server) blobStats() cafs.Stats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.statsAt.IsZero() || time.Since(s.statsAt) > statsTTL {
+		s.stats = s.fs.Stats()
+		s.statsAt = time.Now()
+	}
+	return s.stats
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
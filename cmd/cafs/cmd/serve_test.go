@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aweris/cafs"
+)
+
+func newTestServer(t *testing.T, allowWrite bool) (*server, cafs.Store) {
+	t.Helper()
+	fs, err := cafs.Open("serve:main", cafs.WithCacheDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = fs.Close() })
+	return newServer(fs, allowWrite, "secret"), fs
+}
+
+func serve(s *server, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeReadAndMetrics(t *testing.T) {
+	s, fs := newTestServer(t, false)
+	if err := fs.Put("hello.txt", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(s, http.MethodGet, "/healthz", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("healthz = %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodGet, "/hello.txt", "", ""); rec.Code != http.StatusOK || rec.Body.String() != "hi" {
+		t.Errorf("GET = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := serve(s, http.MethodGet, "/missing", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing = %d", rec.Code)
+	}
+
+	body := serve(s, http.MethodGet, "/metrics", "", "").Body.String()
+	for _, line := range []string{
+		"cafs_cache_hits_total 1",
+		"cafs_cache_misses_total 1",
+		"cafs_bytes_served_total 2",
+		"cafs_entries 1",
+		"cafs_blobs 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestServeWrites(t *testing.T) {
+	s, fs := newTestServer(t, true)
+
+	if rec := serve(s, http.MethodPut, "/k", "", "v"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("PUT without token = %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodPut, "/k", "wrong", "v"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("PUT with wrong token = %d", rec.Code)
+	}
+	rec := serve(s, http.MethodPut, "/k", "secret", "value")
+	if rec.Code != http.StatusCreated || rec.Header().Get("ETag") == "" {
+		t.Fatalf("PUT = %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	if fs.Dirty() {
+		t.Error("PUT acknowledged before the index was synced")
+	}
+
+	rec = serve(s, http.MethodHead, "/k", "", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "5" || rec.Body.Len() != 0 {
+		t.Errorf("HEAD = %d, Content-Length %q, body %d bytes", rec.Code, rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+
+	if rec := serve(s, http.MethodDelete, "/k", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", rec.Code)
+	}
+	if fs.Exists("k") || fs.Dirty() {
+		t.Errorf("after DELETE: exists %v, dirty %v", fs.Exists("k"), fs.Dirty())
+	}
+	if rec := serve(s, http.MethodDelete, "/k", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE = %d", rec.Code)
+	}
+}
+
+func TestServeReadOnlyRejectsWrites(t *testing.T) {
+	s, _ := newTestServer(t, false)
+	rec := serve(s, http.MethodPut, "/k", "secret", "v")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("PUT = %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,100 @@
+package cafs
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// pushDebouncer coalesces Push calls made within delay of each other into a
+// single push of the latest state, covering every tag requested.
+type pushDebouncer struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	tags    []string
+	waiters []chan error
+}
+
+// PushAsync schedules a push to tags and returns a channel that receives its
+// result. With WithPushDebounce, calls within the window share one push, run
+// once the window passes without another call; otherwise the push starts
+// right away. The push runs under the store's background context, not ctx,
+// and CloseContext flushes and waits for it.
+func (s *CAS) PushAsync(tags ...string) <-chan error {
+	result := make(chan error, 1)
+	if s.remote == nil {
+		result <- ErrNoRemote
+		return result
+	}
+	if s.readOnly {
+		result <- ErrReadOnly
+		return result
+	}
+	if len(tags) == 0 {
+		tags = []string{s.remote.Tag()}
+	}
+
+	d := s.debounce
+	if d == nil {
+		s.bg.Add(1)
+		go func() {
+			defer s.bg.Done()
+			result <- s.push(s.bgCtx, tags)
+		}()
+		return result
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, tag := range tags {
+		if !slices.Contains(d.tags, tag) {
+			d.tags = append(d.tags, tag)
+		}
+	}
+	d.waiters = append(d.waiters, result)
+	if d.timer != nil {
+		// A timer that already fired has yet to collect the waiters, so
+		// this call rides along with it.
+		if d.timer.Stop() {
+			d.timer.Reset(d.delay)
+		}
+		return result
+	}
+	s.bg.Add(1)
+	d.timer = time.AfterFunc(d.delay, func() { s.firePush() })
+	return result
+}
+
+// firePush runs the scheduled push and hands its result to every caller it
+// covers.
+func (s *CAS) firePush() {
+	defer s.bg.Done()
+	d := s.debounce
+
+	d.mu.Lock()
+	tags, waiters := d.tags, d.waiters
+	d.timer, d.tags, d.waiters = nil, nil, nil
+	d.mu.Unlock()
+
+	err := s.push(s.bgCtx, tags)
+
+	for _, w := range waiters {
+		w <- err
+	}
+}
+
+// flushPush starts a scheduled push now rather than when its window ends.
+func (s *CAS) flushPush() {
+	d := s.debounce
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	fire := d.timer != nil && d.timer.Stop()
+	d.mu.Unlock()
+	if fire {
+		go s.firePush()
+	}
+}
@@ -0,0 +1,106 @@
+package cafs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+// newManifestCountingRegistry is newTestRegistry, also counting manifest
+// uploads, one per pushed tag.
+func newManifestCountingRegistry(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	var writes atomic.Int64
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			writes.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &writes
+}
+
+func TestPushDebounceCoalesces(t *testing.T) {
+	host, writes := newManifestCountingRegistry(t)
+	ref := host + "/repo:main"
+	s := newTestStore(t, WithRemote(ref), WithPushDebounce(200*time.Millisecond))
+
+	var results []<-chan error
+	for i := range 20 {
+		mustPut(t, s, "counter", fmt.Sprint(i))
+		if err := s.Push(context.Background()); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		results = append(results, s.PushAsync())
+	}
+	for _, result := range results {
+		if err := <-result; err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if n := writes.Load(); n != 1 {
+		t.Errorf("%d remote writes for 40 quick pushes, want 1", n)
+	}
+
+	fresh := newTestStore(t, WithRemote(ref))
+	if err := fresh.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := mustGet(t, fresh, "counter"); got != "19" {
+		t.Errorf("pushed counter = %q, want the latest, 19", got)
+	}
+}
+
+func TestCloseFlushesDebouncedPush(t *testing.T) {
+	host, writes := newManifestCountingRegistry(t)
+	s, err := Open("test:main", WithCacheDir(t.TempDir()), WithRemote(host+"/repo:main"), WithPushDebounce(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, s, "a", "a")
+	if err := s.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := writes.Load(); n != 1 {
+		t.Errorf("%d remote writes after Close, want the scheduled push", n)
+	}
+}
+
+func TestPutDuringPushStaysPending(t *testing.T) {
+	var s *CAS
+	var late Digest
+	s = newTestStore(t, WithRemote(newTestRegistry(t)+"/repo:main"), WithTransferHook(func(TransferReport) {
+		// Runs inside the push, after the upload.
+		if late == "" {
+			mustPut(t, s, "late", "stored while pushing")
+			info, _ := s.Stat("late")
+			late = info.Digest
+		}
+	}))
+	mustPut(t, s, "early", "pushed")
+	early, _ := s.Stat("early")
+
+	if err := s.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if isPending(s, early.Digest) {
+		t.Error("pushed blob still pending")
+	}
+	if !isPending(s, late) {
+		t.Error("blob stored during the push was dropped from pending")
+	}
+}
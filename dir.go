@@ -0,0 +1,225 @@
+package cafs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirOption configures SyncToDir.
+type DirOption func(*dirOptions)
+
+type dirOptions struct {
+	deleteExtra bool
+}
+
+// WithDeleteExtra removes files in the destination that have no entry in the
+// store under the synced prefix.
+func WithDeleteExtra() DirOption {
+	return func(o *dirOptions) { o.deleteExtra = true }
+}
+
+// SyncToDir writes entries under prefix into destDir, keyed by their path
+// relative to prefix. Files whose content already matches the stored digest
+// are left untouched, so restoring into a mostly up-to-date checkout only
+// writes the delta. Directory entries (see MkdirEntry) are created even when
+// empty. Keys that aren't valid relative paths are skipped.
+func (s *CAS) SyncToDir(destDir, prefix string, opts ...DirOption) (written, deleted int, err error) {
+	var o dirOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	wanted := make(map[string]struct{})
+	for rel, info := range s.List(prefix) {
+		if IsDirKey(rel) {
+			dir := strings.TrimSuffix(rel, "/")
+			if fs.ValidPath(dir) && dir != "." {
+				if err := os.MkdirAll(filepath.Join(destDir, filepath.FromSlash(dir)), 0755); err != nil {
+					return written, deleted, fmt.Errorf("mkdir %s: %w", dir, err)
+				}
+			}
+			continue
+		}
+		if !fs.ValidPath(rel) || rel == "." {
+			continue
+		}
+		path := filepath.Join(destDir, filepath.FromSlash(rel))
+		wanted[path] = struct{}{}
+
+		if digest, err := fileDigest(path); err == nil && digest == info.Digest {
+			continue
+		}
+
+		data, err := s.readBlob(info.Digest)
+		if err != nil {
+			return written, deleted, fmt.Errorf("read %s: %w", rel, err)
+		}
+		if err := writeFileAtomic(path, data, fileMode(info)); err != nil {
+			return written, deleted, fmt.Errorf("write %s: %w", rel, err)
+		}
+		written++
+	}
+
+	if !o.deleteExtra {
+		return written, deleted, nil
+	}
+
+	err = filepath.WalkDir(destDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := wanted[path]; ok {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	return written, deleted, err
+}
+
+// ImportDir stores every regular file under srcDir at prefix + its slash
+// separated relative path, with FileMeta recorded. Empty directories are kept
+// as directory entries (see MkdirEntry). It returns the number of entries
+// written.
+func (s *CAS) ImportDir(srcDir, prefix string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		key := prefix + filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				if err := s.MkdirEntry(key); err != nil {
+					return fmt.Errorf("mkdir %s: %w", key, err)
+				}
+				count++
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = s.PutReader(key, f, WithMeta(FileMetaFrom(fi)))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("put %s: %w", key, err)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// WriteChecksums writes a SHA256SUMS-style listing of entries under prefix:
+// one "<hex-digest>  <path>" line per entry, sorted by path, with paths
+// relative to prefix. Digests are plain SHA-256 over the stored content, so
+// after SyncToDir(dir, prefix) the output verifies with "sha256sum -c" run in
+// dir. Keys that SyncToDir would skip are omitted here too.
+func (s *CAS) WriteChecksums(w io.Writer, prefix string) error {
+	digests := make(map[string]Digest)
+	var paths []string
+	for rel, info := range s.List(prefix) {
+		if !fs.ValidPath(rel) || rel == "." {
+			continue
+		}
+		digests[rel] = info.Digest
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		hash := strings.TrimPrefix(string(digests[rel]), digestPrefix)
+		if _, err := io.WriteString(w, checksumLine(hash, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumLine formats a line the way sha256sum does, escaping names that
+// contain a backslash or newline.
+func checksumLine(hash, name string) string {
+	if strings.ContainsAny(name, "\\\n") {
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+		return "\\" + hash + "  " + name + "\n"
+	}
+	return hash + "  " + name + "\n"
+}
+
+func fileDigest(path string) (Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digest, _, err := computeDigestReader(f)
+	return digest, err
+}
+
+func fileMode(info Info) os.FileMode {
+	var meta FileMeta
+	if info.DecodeMeta(&meta) == nil {
+		if perm := meta.Mode.Perm(); perm != 0 {
+			return perm
+		}
+	}
+	return 0644
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	// WriteFile doesn't change the mode of an existing file
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
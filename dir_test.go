@@ -0,0 +1,96 @@
+package cafs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncToDirWritesDelta(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "app/a.txt", "a")
+	mustPut(t, s, "app/sub/b.txt", "b")
+	dest := t.TempDir()
+
+	written, deleted, err := s.SyncToDir(dest, "app/")
+	if err != nil {
+		t.Fatalf("SyncToDir: %v", err)
+	}
+	if written != 2 || deleted != 0 {
+		t.Errorf("first sync wrote %d, deleted %d; want 2, 0", written, deleted)
+	}
+
+	mustPut(t, s, "app/a.txt", "changed")
+	if err := os.WriteFile(filepath.Join(dest, "extra.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written, deleted, err = s.SyncToDir(dest, "app/", WithDeleteExtra())
+	if err != nil {
+		t.Fatalf("SyncToDir: %v", err)
+	}
+	if written != 1 || deleted != 1 {
+		t.Errorf("second sync wrote %d, deleted %d; want 1, 1", written, deleted)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "changed" {
+		t.Errorf("a.txt = %q, want %q", data, "changed")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "extra.txt")); !os.IsNotExist(err) {
+		t.Error("extra.txt survived WithDeleteExtra")
+	}
+}
+
+func TestWriteChecksums(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "out/b.bin", "bee")
+	mustPut(t, s, "out/a.bin", "ay")
+	mustPut(t, s, "out/odd\nname", "x")
+	mustPut(t, s, "elsewhere", "skip")
+
+	var buf bytes.Buffer
+	if err := s.WriteChecksums(&buf, "out/"); err != nil {
+		t.Fatalf("WriteChecksums: %v", err)
+	}
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	want := sum("ay") + "  a.bin\n" +
+		sum("bee") + "  b.bin\n" +
+		`\` + sum("x") + `  odd\nname` + "\n"
+	if buf.String() != want {
+		t.Errorf("WriteChecksums =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestEmptyDirsSurviveImportAndRestore(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "empty", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "file.txt"), []byte("f"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStore(t)
+	n, err := s.ImportDir(src, "p/")
+	if err != nil {
+		t.Fatalf("ImportDir: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("ImportDir stored %d entries, want 2", n)
+	}
+	if !s.Exists("p/empty/nested/") || !IsDirKey("p/empty/nested/") {
+		t.Fatal("empty directory not recorded as a directory entry")
+	}
+
+	dest := t.TempDir()
+	if _, _, err := s.SyncToDir(dest, "p/"); err != nil {
+		t.Fatalf("SyncToDir: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "empty", "nested")); err != nil || !fi.IsDir() {
+		t.Errorf("empty directory not restored: %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 // Package cafs provides a content-addressable store with OCI registry sync and merkle tree semantics.
 //
 // CAFS stores blobs by content digest and indexes them by string keys.
+// A digest is always "sha256:" plus the SHA-256 of the raw content, with no
+// framing, so it matches sha256sum output and the OCI blob digest of the data.
 // Keys cannot be empty, exceed 1024 bytes, start with "_", or contain ".." or null bytes.
+// Keys ending in "/" are reserved for directory entries created with MkdirEntry.
 // Directory hashes are computed on-demand from the flat index, enabling
 // instant comparison of subtrees without storing tree objects.
 //
@@ -34,6 +37,8 @@
 //	// Maintenance
 //	stats := fs.Stats()           // entry count, blob count, total size
 //	removed, _ := fs.GC()         // remove unreferenced blobs
+//	bad, _ := fs.Verify(ctx)      // missing or corrupt blobs
+//	missing, _ := fs.ValidateComplete() // keys whose blobs are absent
 //	fs.Clear()                    // remove all entries
 //
 // With remote sync:
@@ -42,4 +47,10 @@
 //	fs.Push(ctx)
 //	fs.Pull(ctx)
 //	fmt.Println("remote:", fs.Ref())
+//
+// Read-only, straight from a registry (no local index, blobs fetched lazily):
+//
+//	fs, _ := cafs.OpenRemote("ttl.sh/myorg/cache:main")
+//	defer fs.Close()
+//	data, _ := fs.Get("src/main.go")
 package cafs
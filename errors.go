@@ -1,10 +1,97 @@
 package cafs
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aweris/cafs/internal/remote"
+)
 
 var (
 	ErrNotFound    = errors.New("cafs: not found")
 	ErrNoRemote    = errors.New("cafs: no remote configured")
 	ErrReservedKey = errors.New("cafs: key prefix '_' is reserved")
 	ErrInvalidKey  = errors.New("cafs: invalid key")
+	ErrConflict    = errors.New("cafs: conflicting entries")
+	ErrReadOnly    = errors.New("cafs: store is read-only")
+	ErrTxDone      = errors.New("cafs: transaction already committed or rolled back")
+	ErrClone       = errors.New("cafs: not supported on a clone")
+
+	// ErrSizeMismatch is returned by Get when a blob's length differs from
+	// the size recorded in the index.
+	ErrSizeMismatch = errors.New("cafs: blob size does not match index")
+
+	// ErrPushNotPersisted is returned by Push with WithVerifyAfterPush when
+	// the registry accepted a push but doesn't serve it back.
+	ErrPushNotPersisted = errors.New("cafs: registry did not persist push")
+
+	// ErrDeleteUnsupported is returned by PruneRemoteTags when the registry
+	// does not allow deleting tags.
+	ErrDeleteUnsupported = remote.ErrDeleteUnsupported
+
+	// ErrNoTimestamps is returned by PruneRemoteTags when the tags it would
+	// keep can't be told apart by push time, because they were pushed
+	// without WithPushTimestamps.
+	ErrNoTimestamps = errors.New("cafs: remote tags have no push time; push with WithPushTimestamps")
+
+	// ErrUnsupportedFormat is returned by Pull and OpenRemote when the remote
+	// image was pushed in a newer format than this version reads.
+	ErrUnsupportedFormat = remote.ErrUnsupportedFormat
 )
+
+// AutoPullError reports a failed auto-pull during Open. The store is still
+// usable with whatever was loaded from the local index.
+type AutoPullError struct {
+	Ref string
+	Err error
+}
+
+func (e *AutoPullError) Error() string {
+	return fmt.Sprintf("cafs: auto-pull from %s: %v", e.Ref, e.Err)
+}
+
+func (e *AutoPullError) Unwrap() error { return e.Err }
+
+// ConflictError lists keys whose local and remote content differ when Pull
+// runs with PullErrorOnConflict, or that a store and its clone both changed
+// when MergeFrom runs. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Keys []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("cafs: %d conflicting entries: %s", len(e.Keys), strings.Join(e.Keys, ", "))
+}
+
+func (e *ConflictError) Is(target error) bool { return target == ErrConflict }
+
+// BatchError collects the per-key failures of PutMulti or GetMulti, or the
+// per-store failures of PushAll. Keys not listed succeeded.
+type BatchError struct {
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = fmt.Sprintf("%s: %v", key, e.Errors[key])
+	}
+	return fmt.Sprintf("cafs: %d of batch failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// Unwrap exposes the individual errors to errors.Is and errors.As.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -20,7 +20,7 @@ func main() {
 	// Get info about the entry
 	info, ok := fs.Stat("greeting")
 	if ok {
-		fmt.Printf("Stored: %s (size: %d)\n", info.Digest[:20], info.Size)
+		fmt.Printf("Stored: %s (size: %d)\n", info.Digest.Short(12), info.Size)
 	}
 
 	// Load content by key
@@ -28,5 +28,5 @@ func main() {
 	fmt.Printf("Content: %s\n", data)
 
 	// Root hash changes when entries change
-	fmt.Printf("Root: %s\n", fs.Root()[:20])
+	fmt.Printf("Root: %s\n", fs.Root().Short(12))
 }
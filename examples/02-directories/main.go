@@ -29,20 +29,20 @@ func main() {
 			log.Fatal(err)
 		}
 		info, _ := fs.Stat(path)
-		fmt.Printf("  %s → %s\n", path, info.Digest[:24])
+		fmt.Printf("  %s → %s\n", path, info.Digest.Short(16))
 	}
 
 	// Compute merkle hashes for directories
 	fmt.Println("\n=== Directory hashes (computed, not stored) ===")
-	fmt.Printf("  src/lib/  → %s\n", fs.Hash("src/lib/")[:24])
-	fmt.Printf("  src/      → %s\n", fs.Hash("src/")[:24])
-	fmt.Printf("  tests/    → %s\n", fs.Hash("tests/")[:24])
-	fmt.Printf("  (root)    → %s\n", fs.Root()[:24])
+	fmt.Printf("  src/lib/  → %s\n", fs.Hash("src/lib/").Short(16))
+	fmt.Printf("  src/      → %s\n", fs.Hash("src/").Short(16))
+	fmt.Printf("  tests/    → %s\n", fs.Hash("tests/").Short(16))
+	fmt.Printf("  (root)    → %s\n", fs.Root().Short(16))
 
 	// List files in a directory
 	fmt.Println("\n=== List src/lib/ ===")
 	for name, info := range fs.List("src/lib/") {
-		fmt.Printf("  %s → %s\n", name, info.Digest[:24])
+		fmt.Printf("  %s → %s\n", name, info.Digest.Short(16))
 	}
 
 	// Detect changes
@@ -55,8 +55,8 @@ func main() {
 	newLibHash := fs.Hash("src/lib/")
 	if oldLibHash != newLibHash {
 		fmt.Printf("  src/lib/ changed!\n")
-		fmt.Printf("    old: %s\n", oldLibHash[:24])
-		fmt.Printf("    new: %s\n", newLibHash[:24])
+		fmt.Printf("    old: %s\n", oldLibHash.Short(16))
+		fmt.Printf("    new: %s\n", newLibHash.Short(16))
 	}
 
 	// Deduplication demo
@@ -67,11 +67,11 @@ func main() {
 	i1, _ := fs.Stat("file1")
 	i2, _ := fs.Stat("file2")
 	fmt.Printf("  Same content, same digest: %v\n", i1.Digest == i2.Digest)
-	fmt.Printf("  Digest: %s\n", i1.Digest[:24])
+	fmt.Printf("  Digest: %s\n", i1.Digest.Short(16))
 
 	// Index same content under different keys
 	fs.Put("config/dev.json", content)
 	fs.Put("config/prod.json", content)
 	fmt.Println("  Indexed under config/dev.json and config/prod.json")
-	fmt.Printf("  config/ hash: %s\n", fs.Hash("config/")[:24])
+	fmt.Printf("  config/ hash: %s\n", fs.Hash("config/").Short(16))
 }
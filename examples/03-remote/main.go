@@ -24,7 +24,7 @@ func main() {
 	}
 
 	fs.Put("message", []byte("Hello from CAFS!"))
-	fmt.Printf("Created: root=%s\n", fs.Root()[:20])
+	fmt.Printf("Created: root=%s\n", fs.Root().Short(12))
 
 	// Push to remote
 	fmt.Println("Pushing to remote...")
@@ -48,7 +48,7 @@ func main() {
 	}
 	defer fs2.Close()
 
-	fmt.Printf("Pulled: root=%s\n", fs2.Root()[:20])
+	fmt.Printf("Pulled: root=%s\n", fs2.Root().Short(12))
 	fmt.Printf("Roots match: %v\n", fs2.Root() == rootAfterPush)
 
 	// Verify data
@@ -2,8 +2,11 @@ package cafs
 
 import (
 	"context"
+	"io"
+	"io/fs"
 	"iter"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-viper/mapstructure/v2"
@@ -12,6 +15,29 @@ import (
 // Digest is an OCI content identifier (e.g., "sha256:abc123...").
 type Digest string
 
+// String returns the full digest, algorithm prefix included.
+func (d Digest) String() string {
+	return string(d)
+}
+
+// Hex returns the digest without its algorithm prefix.
+func (d Digest) Hex() string {
+	if _, hex, ok := strings.Cut(string(d), ":"); ok {
+		return hex
+	}
+	return string(d)
+}
+
+// Short returns the first n characters of Hex, for display. It returns the
+// whole of Hex if n is out of range.
+func (d Digest) Short(n int) string {
+	hex := d.Hex()
+	if n <= 0 || n >= len(hex) {
+		return hex
+	}
+	return hex[:n]
+}
+
 // Info represents metadata about a stored entry.
 type Info struct {
 	Digest Digest // content hash
@@ -48,26 +74,58 @@ type Stats struct {
 	TotalSize int64 // total size of all blobs
 }
 
+// TransferReport describes one completed push or pull, as passed to the hook
+// set with WithTransferHook.
+type TransferReport struct {
+	Op              string        `json:"op"` // "push" or "pull"
+	Ref             string        `json:"ref"`
+	RootBefore      Digest        `json:"root_before"`
+	RootAfter       Digest        `json:"root_after"`
+	Layers          int           `json:"layers"` // layers transferred
+	Bytes           int64         `json:"bytes"`  // compressed bytes transferred
+	PrefixesChanged []string      `json:"prefixes_changed"`
+	Duration        time.Duration `json:"duration_ns"`
+}
+
 // Store provides content-addressed storage with OCI sync.
 type Store interface {
 	// Core operations
 	Put(key string, data []byte, opts ...Option) error
+	PutReader(key string, r io.Reader, opts ...Option) error
+	PutBase64Stream(key string, r io.Reader, opts ...Option) error
+	PutMulti(items map[string][]byte, opts ...Option) error
+	MkdirEntry(key string) error
 	Get(key string) ([]byte, error)
+	GetMulti(keys []string) (map[string][]byte, error)
 	Stat(key string) (Info, bool)
+	StatMulti(keys []string) map[string]Info
 	Delete(key string)
 	Clear()
 
 	// Iteration
 	List(prefix string) iter.Seq2[string, Info]
+	FS() fs.FS
+	FSAt(prefix string) fs.FS
+	ImportDir(srcDir, prefix string) (int, error)
+	SyncToDir(destDir, prefix string, opts ...DirOption) (written, deleted int, err error)
+	WriteChecksums(w io.Writer, prefix string) error
+	StreamOut(w io.Writer, prefix string) error
+	StreamIn(r io.Reader) (int, error)
 
 	// Tree hash
 	Hash(prefix string) Digest
+	TreeHash(dir string) Digest
+	RemoteTreeHash(ctx context.Context, dir string) (Digest, error)
+	DiffTrees(ctx context.Context, a, b Digest) ([]Change, error)
 
 	// Sync
 	Sync() error
+	Reopen() error
 	Push(ctx context.Context, tags ...string) error
+	PushAsync(tags ...string) <-chan error
 	Pull(ctx context.Context) error
 	Close() error
+	CloseContext(ctx context.Context) error
 
 	// Status
 	Root() Digest
@@ -76,14 +134,36 @@ type Store interface {
 	Ref() string
 	Exists(key string) bool
 	Stats() Stats
+	KeysForDigest(digest Digest) []string
+	FindByMeta(field, value string) []string
+	LastSyncError() error
+
+	// Speculative changes
+	Clone() Store
+	MergeFrom(clone Store) error
+	Begin() Tx
 
 	// Maintenance
 	GC() (removed int, err error)
+	Verify(ctx context.Context) (bad []Digest, err error)
+	ValidateComplete() (missing []string, err error)
+	PruneRemoteTags(ctx context.Context, keep int) error
+	AutoTuneConcurrency(ctx context.Context) (int, error)
 
 	// Advanced
+	HasBlob(digest Digest) bool
 	Path(digest Digest) string
 }
 
+// Tx buffers Puts and Deletes until Commit applies them together, or
+// Rollback discards them.
+type Tx interface {
+	Put(key string, data []byte, opts ...Option) error
+	Delete(key string)
+	Commit() error
+	Rollback()
+}
+
 // Option configures a Put operation.
 type Option func(*Info)
 
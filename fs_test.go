@@ -0,0 +1,32 @@
+package cafs
+
+import "testing"
+
+func TestDigestForms(t *testing.T) {
+	const hex = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	tests := []struct {
+		d          Digest
+		n          int
+		hex, short string
+	}{
+		{"sha256:" + hex, 12, hex, hex[:12]},
+		{hex, 12, hex, hex[:12]},
+		{"sha256:" + hex, 0, hex, hex},
+		{"sha256:" + hex, -1, hex, hex},
+		{"sha256:" + hex, 64, hex, hex},
+		{"sha256:" + hex, 100, hex, hex},
+		{"sha256:" + hex, 1, hex, hex[:1]},
+		{"", 12, "", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.d.Hex(); got != tt.hex {
+			t.Errorf("%q.Hex() = %q, want %q", tt.d, got, tt.hex)
+		}
+		if got := tt.d.Short(tt.n); got != tt.short {
+			t.Errorf("%q.Short(%d) = %q, want %q", tt.d, tt.n, got, tt.short)
+		}
+		if got := tt.d.String(); got != string(tt.d) {
+			t.Errorf("%q.String() = %q", tt.d, got)
+		}
+	}
+}
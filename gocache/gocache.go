@@ -0,0 +1,237 @@
+// Package gocache serves the Go build cache from a cafs store over the
+// GOCACHEPROG protocol.
+//
+// cmd/go starts the program named by $GOCACHEPROG and talks to it over
+// stdin/stdout: one JSON request per line, each put followed by its body as
+// a base64 JSON string. Outputs are stored under the hex action ID, with the
+// output ID kept in the entry's metadata; hits are served by path, straight
+// from the blob store.
+//
+//	fs, _ := cafs.Open("gocache/default:main")
+//	defer fs.Close()
+//	gocache.NewHandler(fs).Serve(os.Stdin, os.Stdout)
+package gocache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aweris/cafs"
+)
+
+// Cmd is a GOCACHEPROG command.
+type Cmd string
+
+const (
+	CmdGet   Cmd = "get"
+	CmdPut   Cmd = "put"
+	CmdClose Cmd = "close"
+)
+
+// Request is a request from cmd/go.
+type Request struct {
+	ID       int64  `json:"ID"`
+	Command  Cmd    `json:"Command"`
+	ActionID []byte `json:"ActionID,omitempty"`
+	OutputID []byte `json:"OutputID,omitempty"`
+	BodySize int64  `json:"BodySize,omitempty"`
+}
+
+// Response answers the Request with the same ID. The first response, with
+// ID 0, announces KnownCommands.
+type Response struct {
+	ID            int64  `json:"ID"`
+	Miss          bool   `json:"Miss,omitempty"`
+	OutputID      []byte `json:"OutputID,omitempty"`
+	DiskPath      string `json:"DiskPath,omitempty"`
+	Size          int64  `json:"Size,omitempty"`
+	Err           string `json:"Err,omitempty"`
+	KnownCommands []Cmd  `json:"KnownCommands,omitempty"`
+}
+
+type cacheMeta struct {
+	OutputID string `json:"o" mapstructure:"o"`
+}
+
+// Handler answers GOCACHEPROG requests from a store.
+type Handler struct {
+	fs cafs.Store
+}
+
+// NewHandler returns a Handler backed by fs. The caller owns fs and closes
+// it once Serve returns.
+func NewHandler(fs cafs.Store) *Handler {
+	return &Handler{fs: fs}
+}
+
+// Serve reads requests from r and writes responses to w until cmd/go sends
+// close or r ends. Failed gets and puts are reported in the response, not
+// returned; only protocol and I/O errors end Serve early.
+func (h *Handler) Serve(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	enc := json.NewEncoder(w)
+
+	if err := enc.Encode(Response{KnownCommands: []Cmd{CmdGet, CmdPut, CmdClose}}); err != nil {
+		return err
+	}
+
+	for {
+		// cmd/go writes each request as one JSON line, followed by the body
+		// as a base64 JSON string when BodySize > 0.
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return fmt.Errorf("decode request: %w", err)
+		}
+
+		var body io.Reader = strings.NewReader("")
+		if req.BodySize > 0 {
+			body = &jsonStringReader{r: br}
+		}
+
+		resp := h.handle(req, body)
+		// Consume whatever the handler didn't read so the next request
+		// starts at a line boundary.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+
+		if req.Command == CmdClose {
+			return nil
+		}
+	}
+}
+
+func (h *Handler) handle(req Request, body io.Reader) Response {
+	switch req.Command {
+	case CmdGet:
+		return h.get(req)
+	case CmdPut:
+		return h.put(req, body)
+	case CmdClose:
+		return Response{ID: req.ID}
+	default:
+		return Response{ID: req.ID, Err: "unknown command"}
+	}
+}
+
+func (h *Handler) get(req Request) Response {
+	actionID := hex.EncodeToString(req.ActionID)
+
+	info, ok := h.fs.Stat(actionID)
+	if !ok {
+		return Response{ID: req.ID, Miss: true}
+	}
+
+	var meta cacheMeta
+	if err := info.DecodeMeta(&meta); err != nil {
+		return Response{ID: req.ID, Miss: true}
+	}
+
+	outputID, err := hex.DecodeString(meta.OutputID)
+	if err != nil {
+		return Response{ID: req.ID, Miss: true}
+	}
+
+	return Response{
+		ID:       req.ID,
+		OutputID: outputID,
+		DiskPath: h.fs.Path(info.Digest),
+		Size:     info.Size,
+	}
+}
+
+// put streams the base64 body into the store, so large outputs are never
+// held in memory.
+func (h *Handler) put(req Request, body io.Reader) Response {
+	actionID := hex.EncodeToString(req.ActionID)
+	outputID := hex.EncodeToString(req.OutputID)
+
+	meta := cacheMeta{OutputID: outputID}
+	if err := h.fs.PutBase64Stream(actionID, body, cafs.WithMeta(meta)); err != nil {
+		return Response{ID: req.ID, Err: err.Error()}
+	}
+
+	info, _ := h.fs.Stat(actionID)
+	return Response{
+		ID:       req.ID,
+		DiskPath: h.fs.Path(info.Digest),
+		Size:     info.Size,
+	}
+}
+
+// jsonStringReader yields the contents of a JSON string read from r without
+// buffering it whole. It only supports strings without escapes, which holds
+// for base64.
+type jsonStringReader struct {
+	r       *bufio.Reader
+	started bool
+	done    bool
+}
+
+func (j *jsonStringReader) Read(p []byte) (int, error) {
+	if j.done {
+		return 0, io.EOF
+	}
+	if !j.started {
+		c, err := j.skipSpace()
+		if err != nil {
+			return 0, err
+		}
+		if c != '"' {
+			return 0, fmt.Errorf("expected body string, got %q", c)
+		}
+		j.started = true
+	}
+
+	n := 0
+	for n < len(p) {
+		c, err := j.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+		switch c {
+		case '"':
+			j.done = true
+			return n, io.EOF
+		case '\\':
+			return n, fmt.Errorf("unexpected escape in body")
+		}
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
+
+func (j *jsonStringReader) skipSpace() (byte, error) {
+	for {
+		c, err := j.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package gocache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aweris/cafs"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	fs, err := cafs.Open("gocache:main", cafs.WithCacheDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = fs.Close() })
+	return NewHandler(fs)
+}
+
+// serve runs a session and returns the responses, one per line, with each
+// DiskPath replaced by "file:" and the content of that file.
+func serve(t *testing.T, h *Handler, in string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := h.Serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	var lines []string
+	for line := range strings.Lines(out.String()) {
+		var resp Response
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("response %q: %v", line, err)
+		}
+		if resp.DiskPath != "" {
+			data, err := os.ReadFile(resp.DiskPath)
+			if err != nil {
+				t.Fatalf("response %d: %v", resp.ID, err)
+			}
+			resp.DiskPath = "file:" + string(data)
+		}
+		norm, _ := json.Marshal(resp)
+		lines = append(lines, string(norm))
+	}
+	return lines
+}
+
+// Sessions recorded from cmd/go, with the responses expected for them.
+var sessions = []struct {
+	name string
+	in   string
+	want []string
+}{
+	{
+		name: "miss, put, hit",
+		in: `{"ID":1,"Command":"get","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+{"ID":2,"Command":"put","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=","OutputID":"qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo=","BodySize":5}
+"aGVsbG8="
+{"ID":3,"Command":"get","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+{"ID":4,"Command":"close"}
+`,
+		want: []string{
+			`{"ID":0,"KnownCommands":["get","put","close"]}`,
+			`{"ID":1,"Miss":true}`,
+			`{"ID":2,"DiskPath":"file:hello","Size":5}`,
+			`{"ID":3,"OutputID":"qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo=","DiskPath":"file:hello","Size":5}`,
+			`{"ID":4}`,
+		},
+	},
+	{
+		name: "empty output has no body",
+		in: `{"ID":1,"Command":"put","ActionID":"AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=","OutputID":"qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo="}
+{"ID":2,"Command":"get","ActionID":"AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="}
+`,
+		want: []string{
+			`{"ID":0,"KnownCommands":["get","put","close"]}`,
+			`{"ID":1,"DiskPath":"file:"}`,
+			`{"ID":2,"OutputID":"qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo=","DiskPath":"file:"}`,
+		},
+	},
+	{
+		name: "unknown command",
+		in: `{"ID":1,"Command":"stat"}
+{"ID":2,"Command":"close"}
+`,
+		want: []string{
+			`{"ID":0,"KnownCommands":["get","put","close"]}`,
+			`{"ID":1,"Err":"unknown command"}`,
+			`{"ID":2}`,
+		},
+	},
+}
+
+func TestRecordedSessions(t *testing.T) {
+	for _, tt := range sessions {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serve(t, newTestHandler(t), tt.in)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("responses:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLargeBody(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 1<<18) // 4MiB
+	in := fmt.Sprintf(`{"ID":1,"Command":"put","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=","OutputID":"qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo=","BodySize":%d}
+"%s"
+{"ID":2,"Command":"get","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+`, len(body), base64.StdEncoding.EncodeToString(body))
+
+	var out bytes.Buffer
+	if err := newTestHandler(t).Serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	dec := json.NewDecoder(&out)
+	var resps []Response
+	for dec.More() {
+		var resp Response
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 3 {
+		t.Fatalf("got %d responses, want 3", len(resps))
+	}
+	hit := resps[2]
+	if hit.Miss || hit.Size != int64(len(body)) {
+		t.Fatalf("get after large put = %+v", hit)
+	}
+	data, err := os.ReadFile(hit.DiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(data) != sha256.Sum256(body) {
+		t.Error("stored output differs from the body")
+	}
+}
+
+func TestMalformedBody(t *testing.T) {
+	in := `{"ID":1,"Command":"put","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=","BodySize":5}
+"aGVs\bG8="
+{"ID":2,"Command":"get","ActionID":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+`
+	got := serve(t, newTestHandler(t), in)
+	if len(got) != 3 || !strings.Contains(got[1], `"Err":"`) || got[2] != `{"ID":2,"Miss":true}` {
+		t.Errorf("responses = %v, want a failed put and then a miss", got)
+	}
+}
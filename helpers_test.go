@@ -0,0 +1,54 @@
+package cafs
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+// newTestStore opens a store in a fresh cache directory and closes it when
+// the test ends.
+func newTestStore(t *testing.T, opts ...OpenOption) *CAS {
+	t.Helper()
+	return openTestStore(t, t.TempDir(), opts...)
+}
+
+// openTestStore opens test:main in dir, for tests that reopen a cache.
+func openTestStore(t *testing.T, dir string, opts ...OpenOption) *CAS {
+	t.Helper()
+	s, err := Open("test:main", append([]OpenOption{WithCacheDir(dir)}, opts...)...)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s.(*CAS)
+}
+
+// newTestRegistry starts an in-memory OCI registry and returns its host, to
+// be used as host + "/repo:tag".
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func mustPut(t *testing.T, s Store, key, data string) {
+	t.Helper()
+	if err := s.Put(key, []byte(data)); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func mustGet(t *testing.T, s Store, key string) string {
+	t.Helper()
+	data, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,212 @@
+package cafs
+
+import (
+	"os"
+	"testing"
+)
+
+// syncedStore syncs a=1 and b=2 to a fresh cache and returns the cache dir,
+// the closed store and the index it wrote.
+func syncedStore(t *testing.T) (string, *CAS, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	mustPut(t, s, "a", "1")
+	mustPut(t, s, "b", "2")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, s, data
+}
+
+func TestRecoverDamagedIndex(t *testing.T) {
+	tests := map[string]func(good []byte) []byte{
+		"garbage appended": func(good []byte) []byte { return append(good, `{"c":{"d":"sha2`...) },
+		"truncated":        func(good []byte) []byte { return good[:len(good)/2] },
+	}
+	for name, damage := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, old, good := syncedStore(t)
+			if err := os.WriteFile(old.indexPath(), damage(good), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := openTestStore(t, dir)
+			if err := s.LastSyncError(); err != nil {
+				t.Fatalf("LastSyncError = %v after recovery", err)
+			}
+			if s.Len() != 2 || mustGet(t, s, "a") != "1" || mustGet(t, s, "b") != "2" {
+				t.Fatalf("recovered %d entries, want a and b", s.Len())
+			}
+			if !s.Dirty() {
+				t.Error("recovered store not dirty, the damaged file would stay")
+			}
+			if err := s.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(s.indexPath()); string(data) != string(good) {
+				t.Errorf("index after Sync = %s, want %s", data, good)
+			}
+		})
+	}
+}
+
+func TestRecoverWithoutBackup(t *testing.T) {
+	dir, old, good := syncedStore(t)
+	if err := os.Remove(old.indexPath() + ".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old.indexPath(), good[:len(good)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := openTestStore(t, dir)
+	if s.LastSyncError() == nil || s.Len() != 0 {
+		t.Errorf("unrecoverable index: LastSyncError = %v, %d entries", s.LastSyncError(), s.Len())
+	}
+}
+
+func TestIndexBackupsRotate(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithIndexBackups(2))
+	for _, v := range []string{"1", "2", "3", "4"} {
+		mustPut(t, s, "v", v)
+		if err := s.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups := map[string]string{
+		s.indexPath():        "4",
+		s.indexPath() + ".1": "3",
+		s.indexPath() + ".2": "2",
+	}
+	for path, want := range backups {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("backup missing: %v", err)
+		}
+		m, err := s.decodeIndex(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m["v"].Digest != computeDigest([]byte(want)) {
+			t.Errorf("%s doesn't hold v=%s", path, want)
+		}
+	}
+	if _, err := os.Stat(s.indexPath() + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 2 backups (stat err %v)", err)
+	}
+}
+
+func TestIndexBackupUndoesClear(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithIndexBackups(3))
+	mustPut(t, s, "a", "precious")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s.Clear()
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Roll back by hand, as a user would.
+	data, err := os.ReadFile(s.indexPath() + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.indexPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored := openTestStore(t, dir)
+	if got := mustGet(t, restored, "a"); got != "precious" {
+		t.Errorf("a = %q after restoring the backup", got)
+	}
+}
+
+// externalWrite changes the cache in dir the way a cafs CLI run would.
+func externalWrite(t *testing.T, dir string, change func(s *CAS)) {
+	t.Helper()
+	s := openTestStore(t, dir)
+	change(s)
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopenPicksUpExternalChanges(t *testing.T) {
+	dir := t.TempDir()
+	daemon := openTestStore(t, dir)
+	mustPut(t, daemon, "a", "1")
+	mustPut(t, daemon, "b", "2")
+	if err := daemon.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	externalWrite(t, dir, func(s *CAS) {
+		mustPut(t, s, "b", "changed")
+		mustPut(t, s, "c", "new")
+		s.Delete("a")
+	})
+	if err := daemon.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	if _, ok := daemon.Stat("a"); ok {
+		t.Error("a deleted on disk but still present")
+	}
+	if got := mustGet(t, daemon, "b"); got != "changed" {
+		t.Errorf("b = %q", got)
+	}
+	if got := mustGet(t, daemon, "c"); got != "new" {
+		t.Errorf("c = %q", got)
+	}
+	if daemon.Dirty() {
+		t.Error("store dirty after reloading a clean index")
+	}
+}
+
+func TestReopenMergesUnsyncedChanges(t *testing.T) {
+	for strategy, want := range map[string]string{PullTheirsWins: "theirs", PullOursWins: "ours"} {
+		t.Run(strategy, func(t *testing.T) {
+			dir := t.TempDir()
+			daemon := openTestStore(t, dir, WithPullStrategy(strategy))
+			mustPut(t, daemon, "gone", "deleted on disk")
+			if err := daemon.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			mustPut(t, daemon, "k", "ours")
+			mustPut(t, daemon, "local", "unsynced")
+
+			externalWrite(t, dir, func(s *CAS) {
+				mustPut(t, s, "k", "theirs")
+				mustPut(t, s, "external", "synced")
+				s.Delete("gone")
+			})
+			if err := daemon.Reopen(); err != nil {
+				t.Fatalf("Reopen: %v", err)
+			}
+			if got := mustGet(t, daemon, "k"); got != want {
+				t.Errorf("k = %q, want %q", got, want)
+			}
+			// A key deleted on disk looks like one added here, so it stays.
+			for _, key := range []string{"local", "external", "gone"} {
+				if _, ok := daemon.Stat(key); !ok {
+					t.Errorf("%s missing after merge", key)
+				}
+			}
+		})
+	}
+}
@@ -17,9 +17,20 @@ const (
 	digestLen       = 71               // "sha256:" (7) + hex (64)
 )
 
+// PrefixInfo records where a prefix's blobs live on the remote. Size and
+// DiffID describe the layer, so a later push can list it in its manifest
+// without downloading it; they are empty for prefixes pushed by older
+// versions.
 type PrefixInfo struct {
-	Hash  string `json:"hash"`
-	Layer string `json:"layer"`
+	Hash   string `json:"hash"`
+	Layer  string `json:"layer"`
+	Size   int64  `json:"size,omitempty"`
+	DiffID string `json:"diffID,omitempty"`
+}
+
+// Described reports whether the layer can be listed without fetching it.
+func (p PrefixInfo) Described() bool {
+	return p.Layer != "" && p.Size > 0 && p.DiffID != ""
 }
 
 func GroupByPrefix(objects map[string][]byte) map[string]map[string][]byte {
@@ -45,12 +56,22 @@ func extractPrefix(digest string) string {
 }
 
 func PrefixHash(blobs map[string][]byte) string {
-	if len(blobs) == 0 {
+	sizes := make(map[string]int64, len(blobs))
+	for d, data := range blobs {
+		sizes[d] = int64(len(data))
+	}
+	return PrefixHashOfSizes(sizes)
+}
+
+// PrefixHashOfSizes is PrefixHash computed from blob sizes alone, so callers
+// can tell whether a prefix changed without reading its blobs.
+func PrefixHashOfSizes(sizes map[string]int64) string {
+	if len(sizes) == 0 {
 		return ""
 	}
 
-	digests := make([]string, 0, len(blobs))
-	for d := range blobs {
+	digests := make([]string, 0, len(sizes))
+	for d := range sizes {
 		digests = append(digests, d)
 	}
 	sort.Strings(digests)
@@ -58,7 +79,7 @@ func PrefixHash(blobs map[string][]byte) string {
 	h := sha256.New()
 	for _, d := range digests {
 		h.Write([]byte(d))
-		binary.Write(h, binary.BigEndian, int64(len(blobs[d])))
+		binary.Write(h, binary.BigEndian, sizes[d])
 	}
 
 	return "sha256:" + hex.EncodeToString(h.Sum(nil))
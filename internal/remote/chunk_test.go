@@ -0,0 +1,45 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuildLayerPlanIsDeterministic(t *testing.T) {
+	// Equal sizes leave nothing but the order to decide boundaries.
+	sizes := make(map[string]int64)
+	for i := range 64 {
+		sizes[fmt.Sprintf("%02x", i)] = LayerSoftMax / 3
+	}
+	want := BuildLayerPlan(sizes)
+	for range 20 {
+		if got := BuildLayerPlan(sizes); !reflect.DeepEqual(got, want) {
+			t.Fatalf("BuildLayerPlan changed between calls:\n%v\n%v", got, want)
+		}
+	}
+	if want[0][0] != "00" {
+		t.Errorf("first layer starts at %q, want prefixes in sorted order", want[0][0])
+	}
+}
+
+func TestPackLayerIsDeterministic(t *testing.T) {
+	blobs := make(map[string][]byte)
+	for i := range 32 {
+		blobs[fmt.Sprintf("sha256:%064x", i)] = []byte(fmt.Sprint(i))
+	}
+	want := PackLayer(blobs)
+	for range 20 {
+		if got := PackLayer(blobs); !bytes.Equal(got, want) {
+			t.Fatal("PackLayer output depends on map order")
+		}
+	}
+	unpacked, err := UnpackLayer(want)
+	if err != nil {
+		t.Fatalf("UnpackLayer: %v", err)
+	}
+	if !reflect.DeepEqual(unpacked, blobs) {
+		t.Error("UnpackLayer doesn't return what PackLayer packed")
+	}
+}
@@ -0,0 +1,45 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+type limitKey struct{}
+
+// WithRequestLimit returns a context under which at most n registry requests
+// are in flight at once, shared by every remote operation using the context.
+// Concurrent pushes of several stores draw on the one budget instead of each
+// opening its own set of connections.
+func WithRequestLimit(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, limitKey{}, make(chan struct{}, max(n, 1)))
+}
+
+// limitedTransport holds a slot of the context's budget for each round trip.
+// Request bodies, which carry uploads, are sent within it; response bodies are
+// read after the slot is released.
+type limitedTransport struct {
+	base http.RoundTripper
+	sem  chan struct{}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.sem <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-t.sem }()
+	return t.base.RoundTrip(req)
+}
+
+// limitOption returns the transport option for ctx's request limit, if any.
+func limitOption(ctx context.Context) (remote.Option, bool) {
+	sem, ok := ctx.Value(limitKey{}).(chan struct{})
+	if !ok {
+		return nil, false
+	}
+	return remote.WithTransport(&limitedTransport{base: remote.DefaultTransport, sem: sem}), true
+}
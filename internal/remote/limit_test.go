@@ -0,0 +1,77 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowTransport answers every request after a pause, recording the most
+// requests it saw in flight at once.
+type slowTransport struct {
+	inflight, peak atomic.Int64
+}
+
+func (t *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := t.inflight.Add(1)
+	defer t.inflight.Add(-1)
+	for {
+		peak := t.peak.Load()
+		if n <= peak || t.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRequestLimit(t *testing.T) {
+	ctx := WithRequestLimit(context.Background(), 3)
+	base := new(slowTransport)
+	// Two transports on one context, as two stores pushing under PushAll.
+	transports := []http.RoundTripper{
+		&limitedTransport{base: base, sem: ctx.Value(limitKey{}).(chan struct{})},
+		&limitedTransport{base: base, sem: ctx.Value(limitKey{}).(chan struct{})},
+	}
+
+	var wg sync.WaitGroup
+	for i := range 24 {
+		wg.Go(func() {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://registry.test/v2/", nil)
+			if _, err := transports[i%2].RoundTrip(req); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if peak := base.peak.Load(); peak != 3 {
+		t.Errorf("peak of %d requests in flight, want 3", peak)
+	}
+}
+
+func TestRequestLimitHonorsCancel(t *testing.T) {
+	ctx := WithRequestLimit(context.Background(), 1)
+	sem := ctx.Value(limitKey{}).(chan struct{})
+	sem <- struct{}{} // budget taken
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://registry.test/v2/", nil)
+	tr := &limitedTransport{base: new(slowTransport), sem: sem}
+	if _, err := tr.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip waiting for a slot: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestNoRequestLimitByDefault(t *testing.T) {
+	if _, ok := limitOption(context.Background()); ok {
+		t.Error("limit applied without WithRequestLimit")
+	}
+	if _, ok := limitOption(WithRequestLimit(context.Background(), 2)); !ok {
+		t.Error("WithRequestLimit not picked up")
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -23,10 +26,24 @@ import (
 
 const DefaultConcurrency = 4
 
+// Image format versions, recorded in the dev.cafs.format label. Images
+// without the label are FormatPlain. Readers reject images newer than
+// MaxFormat rather than misread them.
+const (
+	FormatPlain   = 1 // every indexed blob is pushed whole
+	FormatChunked = 2 // some blobs are pushed only as the chunks the index lists
+	MaxFormat     = FormatChunked
+)
+
+// ErrUnsupportedFormat is returned when an image was pushed in a format newer
+// than this version can read.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
 type OCIRemote struct {
 	ref         name.Reference
 	auth        Authenticator
 	concurrency int
+	timestamps  bool // record dev.cafs.created on push
 }
 
 // NewOCIRemote creates a remote from a standard Docker ref (e.g., "ttl.sh/cache/go:main")
@@ -45,6 +62,12 @@ func (r *OCIRemote) SetConcurrency(n int) {
 	}
 }
 
+// SetTimestamps makes Push record the push time in the dev.cafs.created
+// label. Off by default, so pushing the same content yields the same manifest.
+func (r *OCIRemote) SetTimestamps(on bool) {
+	r.timestamps = on
+}
+
 func (r *OCIRemote) String() string   { return r.ref.String() }
 func (r *OCIRemote) Registry() string { return r.ref.Context().RegistryStr() }
 func (r *OCIRemote) Tag() string      { return r.ref.Identifier() }
@@ -55,7 +78,9 @@ func (r *OCIRemote) WithTag(tag string) (*OCIRemote, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &OCIRemote{ref: newRef, auth: r.auth, concurrency: r.concurrency}, nil
+	c := *r
+	c.ref = newRef
+	return &c, nil
 }
 
 // blobLayer implements v1.Layer with zstd compression for remote transfer
@@ -92,8 +117,35 @@ func (l *blobLayer) Uncompressed() (io.ReadCloser, error) {
 func (l *blobLayer) Size() (int64, error)                { return int64(len(l.compressed)), nil }
 func (l *blobLayer) MediaType() (types.MediaType, error) { return types.OCILayerZStd, nil }
 
-// Push uploads blobs incrementally based on prefix hashes
-func (r *OCIRemote) Push(ctx context.Context, rootHash string, objects map[string][]byte, localPrefixes map[string]PrefixInfo) (map[string]PrefixInfo, error) {
+// PushResult holds the outcome of a Push.
+type PushResult struct {
+	Prefixes        map[string]PrefixInfo // prefix hashes as now on the remote
+	ChangedPrefixes []string              // prefixes whose layers were uploaded, sorted
+	Layers          int                   // layers uploaded, including the index layer
+	Bytes           int64                 // compressed bytes uploaded
+}
+
+// Push uploads blobs incrementally based on prefix hashes. The index is always
+// uploaded as its own layer, placed first in the manifest and referenced by the
+// dev.cafs.index label, so pulls can locate it without scanning content layers.
+//
+// objects holds every blob of each prefix the push may rewrite. Prefixes in
+// localPrefixes that objects has nothing for are carried forward, their
+// layers listed in the new manifest as they are, so the image keeps serving
+// content pushed earlier. Callers leave out prefixes that no longer hold
+// anything; carried prefixes must be Described.
+//
+// Output is reproducible: for the same inputs the layer boundaries, layer
+// order, layer bytes and config are identical. The only time-dependent field
+// is the dev.cafs.created label, written only with SetTimestamps; it honors
+// SOURCE_DATE_EPOCH so CI can pin it and still get byte-identical manifests.
+//
+// treeRoot, if set, is recorded in the dev.cafs.tree label as the digest of
+// the root tree object, which must be among the pushed or carried objects.
+// format is recorded in the dev.cafs.format label.
+func (r *OCIRemote) Push(ctx context.Context, rootHash, treeRoot string, format int, index []byte, objects map[string][]byte, localPrefixes map[string]PrefixInfo) (*PushResult, error) {
+	indexLayer := newBlobLayer(index)
+
 	// Group blobs by prefix
 	byPrefix := GroupByPrefix(objects)
 
@@ -105,29 +157,33 @@ func (r *OCIRemote) Push(ctx context.Context, rootHash string, objects map[strin
 		currentHashes[prefix] = PrefixHash(blobs)
 	}
 
-	// Find changed prefixes
+	// Find changed prefixes, sorted so nothing downstream depends on map order.
+	// Layers older versions pushed can't be listed without fetching them, so
+	// their prefixes are uploaded again.
 	var changedPrefixes []string
 	for prefix, hash := range currentHashes {
-		if local, ok := localPrefixes[prefix]; !ok || local.Hash != hash {
+		if local, ok := localPrefixes[prefix]; !ok || local.Hash != hash || !local.Described() {
 			changedPrefixes = append(changedPrefixes, prefix)
 		}
 	}
+	sort.Strings(changedPrefixes)
 
 	fmt.Fprintf(os.Stderr, "[push] %d prefixes changed (of %d local)\n", len(changedPrefixes), len(localPrefixes))
 
-	// Build result with existing layer refs for unchanged prefixes
+	// Keep existing layer refs for unchanged and carried prefixes
 	newPrefixes := make(map[string]PrefixInfo)
 	for prefix, info := range localPrefixes {
-		if _, exists := currentHashes[prefix]; !exists {
-			continue // prefix no longer exists
+		if hash, ok := currentHashes[prefix]; ok && (hash != info.Hash || !info.Described()) {
+			continue // uploaded again below
+		}
+		if !info.Described() {
+			return nil, fmt.Errorf("prefix %s: layer %s was pushed by an older version; push its blobs again", prefix, info.Layer)
 		}
 		newPrefixes[prefix] = info
 	}
-
-	// If nothing changed, just update manifest
-	if len(changedPrefixes) == 0 {
-		fmt.Fprintf(os.Stderr, "[push] no changes, updating manifest only\n")
-		return newPrefixes, r.pushManifest(ctx, rootHash, newPrefixes)
+	kept, err := keptLayers(newPrefixes)
+	if err != nil {
+		return nil, err
 	}
 
 	// Collect blobs from changed prefixes
@@ -140,34 +196,41 @@ func (r *OCIRemote) Push(ctx context.Context, rootHash string, objects map[strin
 	sizes := CalculatePrefixSizes(changedByPrefix)
 	layerPlan := BuildLayerPlan(sizes)
 
-	fmt.Fprintf(os.Stderr, "[push] packing into %d layers\n", len(layerPlan))
+	fmt.Fprintf(os.Stderr, "[push] packing into %d layers, keeping %d\n", len(layerPlan), len(kept))
 
-	// Create layers
-	layers := make([]v1.Layer, 0, len(layerPlan))
+	// Create layers: index first, then the kept layers, then the new ones
+	layers := make([]v1.Layer, 0, len(layerPlan)+len(kept)+1)
+	layers = append(layers, indexLayer)
+	layers = append(layers, kept...)
 	var totalRaw, totalCompressed int64
+	totalRaw += int64(len(index))
+	totalCompressed += int64(len(indexLayer.compressed))
 	for _, prefixGroup := range layerPlan {
 		blobs := CollectPrefixBlobs(prefixGroup, changedByPrefix)
 		layerData := PackLayer(blobs)
 		layer := newBlobLayer(layerData)
 		digest, _ := layer.Digest()
+		diffID, _ := layer.DiffID()
 		totalRaw += int64(len(layerData))
 		totalCompressed += int64(len(layer.compressed))
 
 		layers = append(layers, layer)
 		for _, prefix := range prefixGroup {
 			newPrefixes[prefix] = PrefixInfo{
-				Hash:  currentHashes[prefix],
-				Layer: digest.String(),
+				Hash:   currentHashes[prefix],
+				Layer:  digest.String(),
+				Size:   int64(len(layer.compressed)),
+				DiffID: diffID.String(),
 			}
 		}
 	}
 
 	ratio := float64(totalCompressed) / float64(totalRaw) * 100
 	fmt.Fprintf(os.Stderr, "[push] uploading %d layers (%.1fMB → %.1fMB, %.0f%%)\n",
-		len(layers), float64(totalRaw)/(1024*1024), float64(totalCompressed)/(1024*1024), ratio)
+		len(layers)-len(kept), float64(totalRaw)/(1024*1024), float64(totalCompressed)/(1024*1024), ratio)
 
 	// Build and push image
-	img, err := r.buildImage(layers, rootHash, newPrefixes)
+	img, err := r.buildImage(layers, rootHash, treeRoot, format, indexLayer, newPrefixes)
 	if err != nil {
 		return nil, fmt.Errorf("build image: %w", err)
 	}
@@ -177,19 +240,60 @@ func (r *OCIRemote) Push(ctx context.Context, rootHash string, objects map[strin
 	}
 
 	fmt.Fprintf(os.Stderr, "[push] done\n")
-	return newPrefixes, nil
+	return &PushResult{
+		Prefixes:        newPrefixes,
+		ChangedPrefixes: changedPrefixes,
+		Layers:          len(layers) - len(kept),
+		Bytes:           totalCompressed,
+	}, nil
 }
 
-// pushManifest pushes just the manifest without new layers
-func (r *OCIRemote) pushManifest(ctx context.Context, rootHash string, prefixes map[string]PrefixInfo) error {
-	img, err := r.buildImage(nil, rootHash, prefixes)
-	if err != nil {
-		return err
+// keptLayer is a content layer already in the repository, listed in a
+// manifest by its recorded descriptor. The registry client skips layers that
+// exist, so its content is only asked for if the registry lost it.
+type keptLayer struct {
+	digest, diffID v1.Hash
+	size           int64
+}
+
+// keptLayers returns the distinct layers prefixes refer to, sorted by digest.
+func keptLayers(prefixes map[string]PrefixInfo) ([]v1.Layer, error) {
+	byLayer := make(map[string]PrefixInfo)
+	for _, info := range prefixes {
+		byLayer[info.Layer] = info
+	}
+	digests := make([]string, 0, len(byLayer))
+	for d := range byLayer {
+		digests = append(digests, d)
 	}
-	return r.pushImage(ctx, img)
+	sort.Strings(digests)
+
+	layers := make([]v1.Layer, 0, len(digests))
+	for _, d := range digests {
+		info := byLayer[d]
+		digest, err := v1.NewHash(info.Layer)
+		if err != nil {
+			return nil, fmt.Errorf("kept layer %q: %w", info.Layer, err)
+		}
+		diffID, err := v1.NewHash(info.DiffID)
+		if err != nil {
+			return nil, fmt.Errorf("kept layer %s: diff ID %q: %w", info.Layer, info.DiffID, err)
+		}
+		layers = append(layers, &keptLayer{digest: digest, diffID: diffID, size: info.Size})
+	}
+	return layers, nil
+}
+
+func (l *keptLayer) Digest() (v1.Hash, error)            { return l.digest, nil }
+func (l *keptLayer) DiffID() (v1.Hash, error)            { return l.diffID, nil }
+func (l *keptLayer) Size() (int64, error)                { return l.size, nil }
+func (l *keptLayer) MediaType() (types.MediaType, error) { return types.OCILayerZStd, nil }
+func (l *keptLayer) Compressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("layer %s is no longer in the repository", l.digest)
 }
+func (l *keptLayer) Uncompressed() (io.ReadCloser, error) { return l.Compressed() }
 
-func (r *OCIRemote) buildImage(layers []v1.Layer, rootHash string, prefixes map[string]PrefixInfo) (v1.Image, error) {
+func (r *OCIRemote) buildImage(layers []v1.Layer, rootHash, treeRoot string, format int, indexLayer *blobLayer, prefixes map[string]PrefixInfo) (v1.Image, error) {
 	img := empty.Image
 
 	if len(layers) > 0 {
@@ -206,17 +310,39 @@ func (r *OCIRemote) buildImage(layers []v1.Layer, rootHash string, prefixes map[
 	}
 
 	prefixJSON, _ := json.Marshal(prefixes)
+	indexDigest, err := indexLayer.Digest()
+	if err != nil {
+		return nil, err
+	}
 
 	cfg.Config.Labels = map[string]string{
 		"dev.cafs.root":     rootHash,
+		"dev.cafs.index":    indexDigest.String(),
 		"dev.cafs.prefixes": string(prefixJSON),
+		"dev.cafs.format":   strconv.Itoa(format),
+	}
+	if r.timestamps {
+		cfg.Config.Labels["dev.cafs.created"] = createdTime().Format(time.RFC3339)
+	}
+	if treeRoot != "" {
+		cfg.Config.Labels["dev.cafs.tree"] = treeRoot
 	}
 
 	return mutate.ConfigFile(img, cfg)
 }
 
+// createdTime returns SOURCE_DATE_EPOCH if set, otherwise the current time.
+func createdTime() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if sec, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
 func (r *OCIRemote) pushImage(ctx context.Context, img v1.Image) error {
-	options := r.remoteOptions()
+	options := r.remoteOptions(ctx)
 	options = append(options, remote.WithJobs(r.concurrency))
 	_, err := retry(ctx, 3, func() (struct{}, error) {
 		return struct{}{}, remote.Write(r.ref, img, options...)
@@ -224,49 +350,48 @@ func (r *OCIRemote) pushImage(ctx context.Context, img v1.Image) error {
 	return err
 }
 
-// Pull downloads blobs incrementally based on prefix hashes
-func (r *OCIRemote) Pull(ctx context.Context, localPrefixes map[string]PrefixInfo) (string, map[string][]byte, map[string]PrefixInfo, error) {
-	img, err := retry(ctx, 3, func() (v1.Image, error) {
-		return remote.Image(r.ref, r.remoteOptions()...)
-	})
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("fetch image: %w", err)
-	}
+// PullResult holds the outcome of a Pull.
+type PullResult struct {
+	Root     string                // digest of the index blob
+	Index    []byte                // index content, nil for images without an index layer
+	Objects  map[string][]byte     // downloaded blobs keyed by digest
+	Prefixes map[string]PrefixInfo // remote prefix hashes
+	Tree     string                // root tree object digest, if trees were pushed
+
+	ChangedPrefixes []string // prefixes whose layers were downloaded, sorted
+	Layers          int      // content layers downloaded
+	Bytes           int64    // compressed bytes of those layers
+}
 
-	cfg, err := img.ConfigFile()
+// Pull downloads blobs incrementally based on prefix hashes
+func (r *OCIRemote) Pull(ctx context.Context, localPrefixes map[string]PrefixInfo) (*PullResult, error) {
+	img, head, err := r.fetchHead(ctx, true)
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("get config: %w", err)
-	}
-
-	rootHash := cfg.Config.Labels["dev.cafs.root"]
-	if rootHash == "" {
-		return "", nil, nil, fmt.Errorf("missing dev.cafs.root label")
-	}
-
-	var remotePrefixes map[string]PrefixInfo
-	if prefixJSON := cfg.Config.Labels["dev.cafs.prefixes"]; prefixJSON != "" {
-		if err := json.Unmarshal([]byte(prefixJSON), &remotePrefixes); err != nil {
-			return "", nil, nil, fmt.Errorf("parse prefixes: %w", err)
-		}
+		return nil, err
 	}
+	rootHash, index, remotePrefixes := head.Root, head.Index, head.Prefixes
 
 	// Find layers we need to download
 	neededLayers := make(map[string]bool)
+	var changedPrefixes []string
 	for prefix, remoteInfo := range remotePrefixes {
 		localInfo, exists := localPrefixes[prefix]
 		if !exists || localInfo.Hash != remoteInfo.Hash {
 			neededLayers[remoteInfo.Layer] = true
+			changedPrefixes = append(changedPrefixes, prefix)
 		}
 	}
+	sort.Strings(changedPrefixes)
 
 	// Download needed layers in parallel
 	layers, err := img.Layers()
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("get layers: %w", err)
+		return nil, fmt.Errorf("get layers: %w", err)
 	}
 
 	// Filter to needed layers
 	var neededLayerList []v1.Layer
+	var totalBytes int64
 	for _, layer := range layers {
 		digest, err := layer.Digest()
 		if err != nil {
@@ -274,6 +399,9 @@ func (r *OCIRemote) Pull(ctx context.Context, localPrefixes map[string]PrefixInf
 		}
 		if neededLayers[digest.String()] {
 			neededLayerList = append(neededLayerList, layer)
+			if size, err := layer.Size(); err == nil {
+				totalBytes += size
+			}
 		}
 	}
 
@@ -314,24 +442,147 @@ func (r *OCIRemote) Pull(ctx context.Context, localPrefixes map[string]PrefixInf
 	}
 
 	if err := p.Wait(); err != nil {
-		return "", nil, nil, err
+		return nil, err
 	}
 
 	fmt.Fprintf(os.Stderr, "[pull] done, %d blobs received\n", len(objects))
-	return rootHash, objects, remotePrefixes, nil
+	return &PullResult{
+		Root:            rootHash,
+		Index:           index,
+		Objects:         objects,
+		Prefixes:        remotePrefixes,
+		Tree:            head.Tree,
+		ChangedPrefixes: changedPrefixes,
+		Layers:          len(neededLayerList),
+		Bytes:           totalBytes,
+	}, nil
 }
 
-func (r *OCIRemote) remoteOptions() []remote.Option {
+// PullIndex fetches the manifest, config and index layer without any content
+// layers. Index is nil for images pushed before the index had its own layer.
+func (r *OCIRemote) PullIndex(ctx context.Context) (*PullResult, error) {
+	_, head, err := r.fetchHead(ctx, true)
+	return head, err
+}
+
+// Head fetches only the manifest and config: Root, Prefixes and Tree.
+func (r *OCIRemote) Head(ctx context.Context) (*PullResult, error) {
+	_, head, err := r.fetchHead(ctx, false)
+	return head, err
+}
+
+// fetchHead resolves the image and reads its labels and, if withIndex is set,
+// its index layer.
+func (r *OCIRemote) fetchHead(ctx context.Context, withIndex bool) (v1.Image, *PullResult, error) {
+	img, err := retry(ctx, 3, func() (v1.Image, error) {
+		return remote.Image(r.ref, r.remoteOptions(ctx)...)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch image: %w", err)
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, fmt.Errorf("get config: %w", err)
+	}
+
+	head := &PullResult{
+		Root: cfg.Config.Labels["dev.cafs.root"],
+		Tree: cfg.Config.Labels["dev.cafs.tree"],
+	}
+	if head.Root == "" {
+		return nil, nil, fmt.Errorf("missing dev.cafs.root label")
+	}
+	if err := checkFormat(cfg.Config.Labels["dev.cafs.format"]); err != nil {
+		return nil, nil, err
+	}
+
+	if prefixJSON := cfg.Config.Labels["dev.cafs.prefixes"]; prefixJSON != "" {
+		if err := json.Unmarshal([]byte(prefixJSON), &head.Prefixes); err != nil {
+			return nil, nil, fmt.Errorf("parse prefixes: %w", err)
+		}
+	}
+
+	// Images pushed before the index had its own layer carry it as a content blob
+	if indexDigest := cfg.Config.Labels["dev.cafs.index"]; withIndex && indexDigest != "" {
+		head.Index, err = readIndexLayer(img, indexDigest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read index layer: %w", err)
+		}
+	}
+	return img, head, nil
+}
+
+// checkFormat rejects a dev.cafs.format label this version can't read.
+func checkFormat(label string) error {
+	if label == "" {
+		return nil
+	}
+	format, err := strconv.Atoi(label)
+	if err != nil || format < FormatPlain {
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, label)
+	}
+	if format > MaxFormat {
+		return fmt.Errorf("%w %d (this version reads up to %d); upgrade cafs", ErrUnsupportedFormat, format, MaxFormat)
+	}
+	return nil
+}
+
+// FetchLayer downloads a single content layer by digest and unpacks its blobs.
+func (r *OCIRemote) FetchLayer(ctx context.Context, digest string) (map[string][]byte, error) {
+	layer, err := retry(ctx, 3, func() (v1.Layer, error) {
+		return remote.Layer(r.ref.Context().Digest(digest), r.remoteOptions(ctx)...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch layer %s: %w", digest, err)
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("read layer %s: %w", digest, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read layer %s: %w", digest, err)
+	}
+	return UnpackLayer(data)
+}
+
+// PrefixOf returns the prefix a blob digest is grouped under.
+func PrefixOf(digest string) string { return extractPrefix(digest) }
+
+func readIndexLayer(img v1.Image, digest string) ([]byte, error) {
+	h, err := v1.NewHash(digest)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := img.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (r *OCIRemote) remoteOptions(ctx context.Context) []remote.Option {
+	options := []remote.Option{remote.WithContext(ctx)}
+	if limit, ok := limitOption(ctx); ok {
+		options = append(options, limit)
+	}
 	if r.auth != nil {
 		username, password, err := r.auth.Authenticate(r.Registry())
 		if err == nil && username != "" {
-			return []remote.Option{remote.WithAuth(&authn.Basic{
+			return append(options, remote.WithAuth(&authn.Basic{
 				Username: username,
 				Password: password,
-			})}
+			}))
 		}
 	}
-	return []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	return append(options, remote.WithAuthFromKeychain(authn.DefaultKeychain))
 }
 
 func retry[T any](ctx context.Context, maxAttempts int, fn func() (T, error)) (T, error) {
@@ -0,0 +1,31 @@
+package remote
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestCheckFormat(t *testing.T) {
+	tests := []struct {
+		label string
+		ok    bool
+	}{
+		{"", true}, // images pushed before the label existed
+		{strconv.Itoa(FormatPlain), true},
+		{strconv.Itoa(FormatChunked), true},
+		{strconv.Itoa(MaxFormat + 1), false},
+		{"0", false},
+		{"-1", false},
+		{"v2", false},
+	}
+	for _, tt := range tests {
+		err := checkFormat(tt.label)
+		if tt.ok && err != nil {
+			t.Errorf("checkFormat(%q) = %v, want nil", tt.label, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("checkFormat(%q) = %v, want ErrUnsupportedFormat", tt.label, err)
+		}
+	}
+}
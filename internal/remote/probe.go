@@ -0,0 +1,55 @@
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// Probe uploads n random layers of size bytes in parallel, downloads them
+// back, and returns the elapsed time. The layers are never referenced by a
+// manifest, so registries garbage-collect them.
+func (r *OCIRemote) Probe(ctx context.Context, n, size int) (time.Duration, error) {
+	layers := make([]*blobLayer, n)
+	for i := range layers {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			return 0, err
+		}
+		layers[i] = newBlobLayer(data)
+	}
+
+	start := time.Now()
+	p := pool.New().WithErrors().WithContext(ctx)
+	for _, layer := range layers {
+		p.Go(func(ctx context.Context) error {
+			repo := r.ref.Context()
+			if err := remote.WriteLayer(repo, layer, r.remoteOptions(ctx)...); err != nil {
+				return fmt.Errorf("upload probe: %w", err)
+			}
+			digest, _ := layer.Digest()
+			fetched, err := remote.Layer(repo.Digest(digest.String()), r.remoteOptions(ctx)...)
+			if err != nil {
+				return fmt.Errorf("download probe: %w", err)
+			}
+			rc, err := fetched.Compressed()
+			if err != nil {
+				return fmt.Errorf("download probe: %w", err)
+			}
+			defer rc.Close()
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				return fmt.Errorf("download probe: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := p.Wait(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,108 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// ErrDeleteUnsupported is returned when the registry refuses tag deletion.
+var ErrDeleteUnsupported = errors.New("registry does not support tag deletion")
+
+// TagInfo describes a CAFS-created tag in the repository.
+type TagInfo struct {
+	Tag     string
+	Root    string
+	Created time.Time // zero for images pushed before dev.cafs.created existed
+}
+
+// Tags lists the tags in the repository whose image carries the dev.cafs.root
+// label, newest first. Tags that can't be inspected, such as image indexes or
+// tags the credentials can't read, aren't CAFS tags and are skipped.
+func (r *OCIRemote) Tags(ctx context.Context) ([]TagInfo, error) {
+	repo := r.ref.Context()
+	names, err := retry(ctx, 3, func() ([]string, error) {
+		return remote.List(repo, r.remoteOptions(ctx)...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+
+	var mu sync.Mutex
+	var tags []TagInfo
+
+	p := pool.New().WithMaxGoroutines(r.concurrency).WithContext(ctx)
+	for _, tag := range names {
+		p.Go(func(ctx context.Context) error {
+			cfg, err := r.tagConfig(ctx, repo.Tag(tag))
+			if err != nil {
+				return ctx.Err() // nil unless cancelled: skip the tag
+			}
+			root := cfg.Config.Labels["dev.cafs.root"]
+			if root == "" {
+				return nil // not a CAFS image
+			}
+			info := TagInfo{Tag: tag, Root: root}
+			if created := cfg.Config.Labels["dev.cafs.created"]; created != "" {
+				info.Created, _ = time.Parse(time.RFC3339, created)
+			}
+			mu.Lock()
+			tags = append(tags, info)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if !tags[i].Created.Equal(tags[j].Created) {
+			return tags[i].Created.After(tags[j].Created)
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+func (r *OCIRemote) tagConfig(ctx context.Context, ref name.Tag) (*v1.ConfigFile, error) {
+	img, err := retry(ctx, 3, func() (v1.Image, error) {
+		return remote.Image(ref, r.remoteOptions(ctx)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img.ConfigFile()
+}
+
+// DeleteTag removes a tag from the repository.
+func (r *OCIRemote) DeleteTag(ctx context.Context, tag string) error {
+	err := remote.Delete(r.ref.Context().Tag(tag), r.remoteOptions(ctx)...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && isUnsupported(terr) {
+		return fmt.Errorf("delete %s: %w", tag, ErrDeleteUnsupported)
+	}
+	return err
+}
+
+func isUnsupported(err *transport.Error) bool {
+	if err.StatusCode == http.StatusMethodNotAllowed {
+		return true
+	}
+	for _, d := range err.Errors {
+		if d.Code == transport.UnsupportedErrorCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package cafs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only io/fs view of the store's entries. Keys are mapped to
+// slash-separated paths and MkdirEntry markers to (possibly empty)
+// directories; keys that aren't valid fs paths (see fs.ValidPath) are
+// omitted. The entry set is captured when FS is called, so later Puts and
+// Deletes are not visible through it.
+func (s *CAS) FS() fs.FS {
+	return newSnapshotFS(s, "")
+}
+
+// FSAt is like FS but rooted at the directory prefix, the equivalent of
+// fs.Sub over the store: FSAt("src") exposes "src/main.go" as "main.go" and
+// hides everything outside src/. A trailing slash on prefix is optional.
+func (s *CAS) FSAt(prefix string) fs.FS {
+	return newSnapshotFS(s, dirPrefix(prefix))
+}
+
+// snapshotFS implements fs.FS, fs.ReadDirFS, fs.ReadFileFS and fs.StatFS over
+// a point-in-time copy of the index.
+type snapshotFS struct {
+	s     *CAS
+	files map[string]Info
+	dirs  map[string][]string // dir -> sorted child names
+}
+
+func newSnapshotFS(s *CAS, prefix string) *snapshotFS {
+	fsys := &snapshotFS{
+		s:     s,
+		files: make(map[string]Info),
+		dirs:  map[string][]string{".": nil},
+	}
+
+	children := make(map[string]map[string]struct{})
+	var dirEntries []string
+	for key, info := range s.List(prefix) {
+		name := strings.TrimSuffix(key, "/")
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		if IsDirKey(key) {
+			dirEntries = append(dirEntries, name)
+		} else {
+			fsys.files[name] = info
+		}
+		for p := name; p != "."; {
+			dir := path.Dir(p)
+			if children[dir] == nil {
+				children[dir] = make(map[string]struct{})
+			}
+			children[dir][path.Base(p)] = struct{}{}
+			p = dir
+		}
+	}
+	for dir, names := range children {
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		fsys.dirs[dir] = list
+	}
+	for _, dir := range dirEntries {
+		if _, ok := fsys.dirs[dir]; !ok {
+			fsys.dirs[dir] = nil
+		}
+	}
+	return fsys
+}
+
+func (f *snapshotFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := f.dirs[name]; ok {
+		return &snapshotDir{fsys: f, name: name}, nil
+	}
+	info, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := f.s.readBlob(info.Digest)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &snapshotFile{info: f.fileInfo(name, info), r: bytes.NewReader(data)}, nil
+}
+
+func (f *snapshotFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	info, ok := f.files[name]
+	if !ok || f.isDir(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := f.s.readBlob(info.Digest)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (f *snapshotFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if f.isDir(name) {
+		return dirInfo(name), nil
+	}
+	info, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.fileInfo(name, info), nil
+}
+
+func (f *snapshotFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	names, ok := f.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, child := range names {
+		fi, err := f.Stat(path.Join(name, child))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fi))
+	}
+	return entries, nil
+}
+
+// isDir reports whether name is a directory. A key that is also the parent of
+// other keys is shadowed by the directory.
+func (f *snapshotFS) isDir(name string) bool {
+	_, ok := f.dirs[name]
+	return ok
+}
+
+func (f *snapshotFS) fileInfo(name string, info Info) fs.FileInfo {
+	fi := &snapshotInfo{name: path.Base(name), size: info.Size, mode: 0644}
+	var meta FileMeta
+	if info.DecodeMeta(&meta) == nil {
+		if perm := meta.Mode.Perm(); perm != 0 {
+			fi.mode = perm
+		}
+		fi.modTime = meta.ModTime
+	}
+	return fi
+}
+
+func dirInfo(name string) fs.FileInfo {
+	return &snapshotInfo{name: path.Base(name), mode: fs.ModeDir | 0755}
+}
+
+type snapshotInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *snapshotInfo) Name() string       { return i.name }
+func (i *snapshotInfo) Size() int64        { return i.size }
+func (i *snapshotInfo) Mode() fs.FileMode  { return i.mode }
+func (i *snapshotInfo) ModTime() time.Time { return i.modTime }
+func (i *snapshotInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *snapshotInfo) Sys() any           { return nil }
+
+type snapshotFile struct {
+	info fs.FileInfo
+	r    *bytes.Reader
+}
+
+func (f *snapshotFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *snapshotFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *snapshotFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+func (f *snapshotFile) ReadAt(p []byte, off int64) (int, error) { return f.r.ReadAt(p, off) }
+func (f *snapshotFile) Close() error                            { return nil }
+
+type snapshotDir struct {
+	fsys   *snapshotFS
+	name   string
+	offset int
+}
+
+func (d *snapshotDir) Stat() (fs.FileInfo, error) { return dirInfo(d.name), nil }
+func (d *snapshotDir) Close() error               { return nil }
+
+func (d *snapshotDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *snapshotDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.fsys.ReadDir(d.name)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[d.offset:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
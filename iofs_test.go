@@ -0,0 +1,59 @@
+package cafs
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "README.md", "# hi")
+	mustPut(t, s, "src/main.go", "package main")
+	mustPut(t, s, "src/lib/util.go", "package lib")
+
+	fsys := s.FS()
+	if err := fstest.TestFS(fsys, "README.md", "src/main.go", "src/lib/util.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The view is a snapshot.
+	mustPut(t, s, "late.txt", "added after FS")
+	if _, err := fs.Stat(fsys, "late.txt"); err == nil {
+		t.Error("FS shows an entry added after it was taken")
+	}
+	data, err := fs.ReadFile(fsys, "src/main.go")
+	if err != nil || string(data) != "package main" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
+
+func TestFSAt(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "README.md", "# hi")
+	mustPut(t, s, "src/main.go", "package main")
+	mustPut(t, s, "src/lib/util.go", "package lib")
+	mustPut(t, s, "srcgen/out.go", "package gen") // shares the prefix, not the dir
+
+	for _, prefix := range []string{"src", "src/"} {
+		fsys := s.FSAt(prefix)
+		if err := fstest.TestFS(fsys, "main.go", "lib/util.go"); err != nil {
+			t.Fatalf("FSAt(%q): %v", prefix, err)
+		}
+
+		var paths []string
+		err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				paths = append(paths, path)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"lib/util.go", "main.go"}; !slices.Equal(paths, want) {
+			t.Errorf("FSAt(%q) walks %v, want %v", prefix, paths, want)
+		}
+	}
+}
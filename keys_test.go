@@ -0,0 +1,99 @@
+package cafs
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var errUppercase = errors.New("uppercase key")
+
+func rejectUppercase(key string) error {
+	if key != strings.ToLower(key) {
+		return errUppercase
+	}
+	return nil
+}
+
+func TestKeyValidator(t *testing.T) {
+	s := newTestStore(t, WithKeyValidator(rejectUppercase))
+	mustPut(t, s, "abc123", "ok")
+
+	if err := s.Put("ABC123", []byte("x")); !errors.Is(err, errUppercase) {
+		t.Errorf("Put: err = %v, want the validator's error", err)
+	}
+	if err := s.PutReader("ABC123", strings.NewReader("x")); !errors.Is(err, errUppercase) {
+		t.Errorf("PutReader: err = %v, want the validator's error", err)
+	}
+	tx := s.Begin()
+	if err := tx.Put("ABC123", []byte("x")); !errors.Is(err, errUppercase) {
+		t.Errorf("tx Put: err = %v, want the validator's error", err)
+	}
+	tx.Rollback()
+
+	if _, err := s.Get("ABC123"); !errors.Is(err, errUppercase) {
+		t.Errorf("Get: err = %v, want the validator's error", err)
+	}
+	if _, ok := s.Stat("ABC123"); ok {
+		t.Error("Stat reports a rejected key")
+	}
+	if s.Exists("ABC123") {
+		t.Error("Exists reports a rejected key")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len = %d, want only the valid key", s.Len())
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	s := newTestStore(t, WithKeyNormalizer(strings.ToLower), WithKeyValidator(rejectUppercase))
+	mustPut(t, s, "ABC123", "stored")
+
+	var keys []string
+	for key := range s.List("") {
+		keys = append(keys, key)
+	}
+	if len(keys) != 1 || keys[0] != "abc123" {
+		t.Fatalf("keys = %v, want [abc123]", keys)
+	}
+	for _, key := range []string{"abc123", "ABC123", "AbC123"} {
+		if got := mustGet(t, s, key); got != "stored" {
+			t.Errorf("Get(%q) = %q", key, got)
+		}
+		if _, ok := s.Stat(key); !ok {
+			t.Errorf("Stat(%q) found nothing", key)
+		}
+	}
+
+	s.Delete("ABC123")
+	if s.Exists("abc123") {
+		t.Error("Delete didn't normalize its key")
+	}
+}
+
+func TestKeyNormalizerRunsOnce(t *testing.T) {
+	// Not idempotent: applied twice it would nest the key.
+	addPrefix := func(key string) string { return "ns/" + key }
+	s := newTestStore(t, WithKeyNormalizer(addPrefix), WithInlineThreshold(16))
+
+	if err := s.PutReader("small", strings.NewReader("inline")); err != nil {
+		t.Fatalf("PutReader inline: %v", err)
+	}
+	if err := s.PutReader("large", strings.NewReader(strings.Repeat("x", 64))); err != nil {
+		t.Fatalf("PutReader: %v", err)
+	}
+	mustPut(t, s, "put", "value")
+
+	var keys []string
+	for key := range s.List("") {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if want := []string{"ns/large", "ns/put", "ns/small"}; !slices.Equal(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+	if got := mustGet(t, s, "small"); got != "inline" {
+		t.Errorf("small = %q, want %q", got, "inline")
+	}
+}
@@ -0,0 +1,129 @@
+package cafs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// metaIndex maps metadata field values to the keys that carry them, for the
+// fields configured with WithSecondaryIndex.
+type metaIndex struct {
+	mu     sync.RWMutex
+	fields map[string]map[string]map[string]struct{} // field -> value -> keys
+}
+
+func newMetaIndex(fields []string) *metaIndex {
+	if len(fields) == 0 {
+		return nil
+	}
+	m := &metaIndex{fields: make(map[string]map[string]map[string]struct{})}
+	for _, f := range fields {
+		m.fields[f] = make(map[string]map[string]struct{})
+	}
+	return m
+}
+
+// update replaces the index entries of key; old and next may be nil.
+func (m *metaIndex) update(key string, old, next *Info) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for field, values := range m.fields {
+		if old != nil {
+			if v, ok := metaValue(*old, field); ok {
+				delete(values[v], key)
+				if len(values[v]) == 0 {
+					delete(values, v)
+				}
+			}
+		}
+		if next != nil {
+			if v, ok := metaValue(*next, field); ok {
+				if values[v] == nil {
+					values[v] = make(map[string]struct{})
+				}
+				values[v][key] = struct{}{}
+			}
+		}
+	}
+}
+
+func (m *metaIndex) fieldNames() []string {
+	if m == nil {
+		return nil
+	}
+	names := make([]string, 0, len(m.fields))
+	for field := range m.fields {
+		names = append(names, field)
+	}
+	return names
+}
+
+func (m *metaIndex) reset() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for field := range m.fields {
+		m.fields[field] = make(map[string]map[string]struct{})
+	}
+}
+
+// lookup returns the keys whose field equals value, and whether field is
+// indexed at all.
+func (m *metaIndex) lookup(field, value string) ([]string, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	values, ok := m.fields[field]
+	if !ok {
+		return nil, false
+	}
+	var keys []string
+	for key := range values[value] {
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+// metaValue returns the named top-level field of info's metadata as a
+// string. Field names follow the mapstructure tags used by DecodeMeta, which
+// match the JSON names metadata is stored under.
+func metaValue(info Info, field string) (string, bool) {
+	var fields map[string]any
+	if info.Meta == nil || info.DecodeMeta(&fields) != nil {
+		return "", false
+	}
+	v, ok := fields[field]
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// FindByMeta returns the sorted keys whose metadata field equals value. Fields
+// configured with WithSecondaryIndex are answered from the index; others fall
+// back to a scan of all entries.
+func (s *CAS) FindByMeta(field, value string) []string {
+	keys, ok := s.metaIndex.lookup(field, value)
+	if !ok {
+		for key, info := range s.List("") {
+			if v, ok := metaValue(info, field); ok && v == value {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
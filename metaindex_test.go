@@ -0,0 +1,57 @@
+package cafs
+
+import (
+	"slices"
+	"testing"
+)
+
+type outputMeta struct {
+	OutputID string `json:"outputID" mapstructure:"outputID"`
+}
+
+func TestFindByMeta(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithSecondaryIndex("outputID"))
+	put := func(key, out string) {
+		t.Helper()
+		if err := s.Put(key, []byte(key), WithMeta(outputMeta{OutputID: out})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	find := func(store *CAS, out string, want ...string) {
+		t.Helper()
+		if got := store.FindByMeta("outputID", out); !slices.Equal(got, want) {
+			t.Errorf("FindByMeta(%q) = %v, want %v", out, got, want)
+		}
+	}
+
+	put("a1", "o1")
+	put("a2", "o1")
+	put("a3", "o2")
+	find(s, "o1", "a1", "a2")
+	find(s, "o2", "a3")
+
+	put("a2", "o2")
+	find(s, "o1", "a1")
+	find(s, "o2", "a2", "a3")
+
+	s.Delete("a3")
+	find(s, "o2", "a2")
+	find(s, "missing")
+
+	if _, indexed := s.metaIndex.lookup("outputID", "o1"); !indexed {
+		t.Error("outputID not answered from the index")
+	}
+
+	// The index is rebuilt when the store is loaded again.
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestStore(t, dir, WithSecondaryIndex("outputID"))
+	find(reopened, "o1", "a1")
+	find(reopened, "o2", "a2")
+
+	// Fields without an index are found by scanning.
+	plain := openTestStore(t, dir)
+	find(plain, "o2", "a2")
+}
@@ -0,0 +1,145 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// newStallingRegistry returns the host of a registry that never answers.
+func newStallingRegistry(t *testing.T) string {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestOpenTimeoutBoundsAutoPull(t *testing.T) {
+	ref := newStallingRegistry(t) + "/repo:main"
+
+	start := time.Now()
+	s := newTestStore(t, WithRemote(ref), WithAutoPull(AutoPullAlways), WithOpenTimeout(200*time.Millisecond))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Open took %v with a 200ms open timeout", elapsed)
+	}
+	if err := s.LastSyncError(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LastSyncError = %v, want a deadline error", err)
+	}
+	mustPut(t, s, "a", "still usable")
+}
+
+func TestAutoPullErrorSurfaced(t *testing.T) {
+	ref := newTestRegistry(t) + "/repo:missing"
+
+	s := newTestStore(t, WithRemote(ref), WithAutoPull(AutoPullAlways))
+	var pullErr *AutoPullError
+	if err := s.LastSyncError(); !errors.As(err, &pullErr) {
+		t.Fatalf("LastSyncError = %v, want *AutoPullError", err)
+	}
+	if pullErr.Ref == "" {
+		t.Error("AutoPullError.Ref is empty")
+	}
+
+	_, err := Open("test:main", WithCacheDir(t.TempDir()), WithRemote(ref), WithAutoPull(AutoPullAlways), WithStrictOpen())
+	if !errors.As(err, &pullErr) {
+		t.Errorf("strict Open = %v, want *AutoPullError", err)
+	}
+}
+
+func TestIndexLoadErrorSurfaced(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test", "main.json"), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestStore(t, dir)
+	if s.LastSyncError() == nil {
+		t.Error("LastSyncError = nil after loading a corrupt index")
+	}
+
+	if _, err := Open("test:main", WithCacheDir(dir), WithStrictOpen()); err == nil {
+		t.Error("strict Open of a corrupt index succeeded")
+	}
+}
+
+func TestCloseContextCancelsBackgroundWork(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithRemote(newStallingRegistry(t)+"/repo:main"))
+	mustPut(t, s, "k", "v")
+	result := s.PushAsync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseContext = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CloseContext took %v", elapsed)
+	}
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("push to a stalling registry succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("cancelled push never finished")
+	}
+
+	// The index was still synced.
+	if got := mustGet(t, openTestStore(t, dir), "k"); got != "v" {
+		t.Errorf("k = %q after reopen", got)
+	}
+}
+
+func TestParseNamespace(t *testing.T) {
+	tests := []struct {
+		in, ns, tag string
+	}{
+		{"foo", "foo", "latest"},
+		{"foo:bar", "foo", "bar"},
+		{"foo:", "foo", "latest"},
+		{"ttl.sh/foo", "ttl.sh/foo", "latest"},
+		{"ttl.sh/foo:1h", "ttl.sh/foo", "1h"},
+		{"localhost:5000/ns", "localhost:5000/ns", "latest"},
+		{"localhost:5000/ns:tag", "localhost:5000/ns", "tag"},
+		{"host:5000/org/repo:v1.2", "host:5000/org/repo", "v1.2"},
+	}
+	for _, tt := range tests {
+		ns, tag := parseNamespace(tt.in)
+		if ns != tt.ns || tag != tt.tag {
+			t.Errorf("parseNamespace(%q) = %q, %q; want %q, %q", tt.in, ns, tag, tt.ns, tt.tag)
+		}
+		// Tags agree with how the same string parses as a remote reference.
+		if tt.in[len(tt.in)-1] == ':' {
+			continue
+		}
+		ref, err := name.NewTag(tt.in, name.WithDefaultTag("latest"))
+		if err != nil {
+			t.Fatalf("name.NewTag(%q): %v", tt.in, err)
+		}
+		if ref.TagStr() != tag {
+			t.Errorf("%q: local tag %q, remote tag %q", tt.in, tag, ref.TagStr())
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package cafs
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/aweris/cafs/internal/remote"
 )
@@ -14,6 +16,14 @@ const (
 	AutoPullMissing = "missing"
 )
 
+// Pull strategies for keys present both locally and in the pulled index with
+// different content.
+const (
+	PullTheirsWins      = "theirs" // remote entry replaces the local one
+	PullOursWins        = "ours"   // local entry is kept
+	PullErrorOnConflict = "error"  // Pull fails with a *ConflictError
+)
+
 // Authenticator provides credentials for remote registries.
 type Authenticator = remote.Authenticator
 
@@ -24,6 +34,61 @@ type OpenOptions struct {
 	Auth        Authenticator
 	AutoPull    string
 	Concurrency int
+	OpenTimeout time.Duration // bounds auto-pull on Open (0 = no limit)
+	StrictOpen  bool          // fail Open on index load or auto-pull errors
+
+	// Fallbacks are remotes Pull tries in order when Remote fails.
+	Fallbacks []string
+	// MirrorPush also pushes to every fallback after the primary.
+	MirrorPush bool
+
+	// PullStrategy resolves keys that differ between local and remote.
+	PullStrategy string
+
+	// InlineThreshold stores blobs of at most this many bytes inside the
+	// index instead of as separate files (0 = disabled).
+	InlineThreshold int
+
+	// IndexInLayerOnly skips pushing the index as a content blob; it is
+	// only uploaded as its own dedicated layer.
+	IndexInLayerOnly bool
+
+	// SecondaryIndexes are metadata fields kept indexed for FindByMeta.
+	SecondaryIndexes []string
+
+	// TransferHook is called after each successful push or pull.
+	TransferHook func(TransferReport)
+
+	// AuditLog receives a hash-chained JSONL record of every mutation.
+	AuditLog io.Writer
+
+	// AuditLogFile is a path the audit log is appended to, continuing its chain.
+	AuditLogFile string
+
+	// PersistTrees pushes a tree object per directory alongside the blobs.
+	PersistTrees bool
+
+	// Chunking pushes large blobs as content-defined chunks.
+	Chunking bool
+
+	// PushDebounce coalesces Push calls made within this window (0 = off).
+	PushDebounce time.Duration
+
+	// IndexBackups is how many previous indexes Sync keeps (0 = none).
+	IndexBackups int
+
+	// HashIgnoreSize computes directory hashes from names and digests only.
+	HashIgnoreSize bool
+
+	// VerifyAfterPush reads each push back from the registry.
+	VerifyAfterPush bool
+
+	// PushTimestamps records the push time in each pushed image.
+	PushTimestamps bool
+
+	// KeyValidator rejects keys; KeyNormalizer rewrites them first.
+	KeyValidator  func(key string) error
+	KeyNormalizer func(key string) string
 }
 
 // OpenOption is a functional option for configuring Open.
@@ -31,9 +96,10 @@ type OpenOption func(*OpenOptions)
 
 func defaultOptions() *OpenOptions {
 	return &OpenOptions{
-		CacheDir:    defaultCacheDir(),
-		AutoPull:    AutoPullNever,
-		Concurrency: remote.DefaultConcurrency,
+		CacheDir:     defaultCacheDir(),
+		AutoPull:     AutoPullNever,
+		Concurrency:  remote.DefaultConcurrency,
+		PullStrategy: PullTheirsWins,
 	}
 }
 
@@ -47,6 +113,24 @@ func WithRemote(imageRef string) OpenOption {
 	return func(o *OpenOptions) { o.Remote = imageRef }
 }
 
+// WithRemotes sets a primary remote followed by fallbacks. Pull tries each in
+// order until one succeeds; Push targets the primary unless WithMirrorPush is
+// also set.
+func WithRemotes(imageRefs ...string) OpenOption {
+	return func(o *OpenOptions) {
+		if len(imageRefs) == 0 {
+			return
+		}
+		o.Remote = imageRefs[0]
+		o.Fallbacks = imageRefs[1:]
+	}
+}
+
+// WithMirrorPush makes Push also upload to every fallback remote.
+func WithMirrorPush() OpenOption {
+	return func(o *OpenOptions) { o.MirrorPush = true }
+}
+
 // WithAuth sets custom authentication for remote operations.
 func WithAuth(auth Authenticator) OpenOption {
 	return func(o *OpenOptions) { o.Auth = auth }
@@ -57,7 +141,34 @@ func WithAutoPull(mode string) OpenOption {
 	return func(o *OpenOptions) { o.AutoPull = mode }
 }
 
-// WithConcurrency sets the number of parallel operations for push/pull.
+// WithOpenTimeout bounds how long auto-pull may run during Open. When it
+// expires, Open returns the local store and the error is available via
+// LastSyncError.
+func WithOpenTimeout(d time.Duration) OpenOption {
+	return func(o *OpenOptions) { o.OpenTimeout = d }
+}
+
+// WithPullStrategy sets how Pull resolves keys that differ between the local
+// and remote index. The default is PullTheirsWins.
+func WithPullStrategy(strategy string) OpenOption {
+	return func(o *OpenOptions) { o.PullStrategy = strategy }
+}
+
+// WithStrictOpen makes Open fail when the local index can't be loaded or
+// auto-pull fails, instead of returning a store that may be silently empty.
+func WithStrictOpen() OpenOption {
+	return func(o *OpenOptions) { o.StrictOpen = true }
+}
+
+// WithInlineThreshold stores blobs of at most n bytes inline in the index
+// rather than as one file each, which cuts inode usage for workloads with many
+// tiny values. Inlined content has no file under Path.
+func WithInlineThreshold(n int) OpenOption {
+	return func(o *OpenOptions) { o.InlineThreshold = n }
+}
+
+// WithConcurrency sets the number of parallel operations for push/pull and
+// for PutMulti/GetMulti.
 func WithConcurrency(n int) OpenOption {
 	return func(o *OpenOptions) {
 		if n > 0 {
@@ -66,6 +177,117 @@ func WithConcurrency(n int) OpenOption {
 	}
 }
 
+// WithIndexInLayerOnly uploads the index only as its dedicated layer, without
+// also bucketing it with content blobs. Images pushed this way cannot be
+// pulled by versions that predate the index layer.
+func WithIndexInLayerOnly() OpenOption {
+	return func(o *OpenOptions) { o.IndexInLayerOnly = true }
+}
+
+// WithSecondaryIndex keeps an in-memory index of the top-level metadata field
+// so FindByMeta can look entries up by its value without a scan. The field is
+// named as in the metadata's mapstructure/JSON tags.
+func WithSecondaryIndex(field string) OpenOption {
+	return func(o *OpenOptions) { o.SecondaryIndexes = append(o.SecondaryIndexes, field) }
+}
+
+// WithTransferHook calls fn with a report after every successful push
+// (including mirror pushes) and pull, e.g. to archive what a CI run did.
+func WithTransferHook(fn func(TransferReport)) OpenOption {
+	return func(o *OpenOptions) { o.TransferHook = fn }
+}
+
+// WithAuditLog appends a JSONL record to w for every Put, MkdirEntry, Delete,
+// Clear, Push and Pull, with timestamp, key, digest and, for Push and Pull,
+// the actor set on the context with WithActor. Each line carries the hash of
+// the previous one so VerifyAuditLog can detect tampering. Write errors fail
+// the operation, except for Delete and Clear, which can't report them. The
+// chain starts afresh with each Open, so give each store its own log, or use
+// WithAuditLogFile to keep appending to one across Opens.
+func WithAuditLog(w io.Writer) OpenOption {
+	return func(o *OpenOptions) { o.AuditLog = w }
+}
+
+// WithAuditLogFile is WithAuditLog on a file that is created if needed and
+// appended to otherwise. The chain continues from the file's last line, so
+// the whole file stays verifiable across Opens. Close closes the file.
+func WithAuditLogFile(path string) OpenOption {
+	return func(o *OpenOptions) { o.AuditLogFile = path }
+}
+
+// WithPersistTrees makes Push also upload a tree object for every directory
+// and record the root tree in the image config, so RemoteTreeHash can answer
+// whether a directory differs from the remote without pulling the index.
+func WithPersistTrees() OpenOption {
+	return func(o *OpenOptions) { o.PersistTrees = true }
+}
+
+// WithChunking makes Push split blobs over 2MiB into content-defined chunks
+// and upload those instead, so a small edit to a large file only uploads the
+// chunks around it. The index lists each chunked blob's chunks, and the image
+// is marked with a newer format so readers that can't reassemble chunks
+// refuse to pull it (with ErrUnsupportedFormat) instead of missing blobs.
+// Readers released before the format marker ignore it, so enable chunking
+// only once every puller is up to date.
+func WithChunking() OpenOption {
+	return func(o *OpenOptions) { o.Chunking = true }
+}
+
+// WithPushDebounce makes Push return immediately and coalesces calls made
+// within d of each other into one push of the latest state, for workflows
+// that push on every change. Use PushAsync to wait for the result, and
+// LastSyncError to check it afterwards; Close flushes a pending push.
+func WithPushDebounce(d time.Duration) OpenOption {
+	return func(o *OpenOptions) { o.PushDebounce = d }
+}
+
+// WithIndexBackups makes Sync keep the last n indexes beside the current one
+// as <tag>.json.1 (newest) to <tag>.json.<n>. To roll back a bad change such
+// as an errant Clear, close the store and copy a backup over <tag>.json.
+func WithIndexBackups(n int) OpenOption {
+	return func(o *OpenOptions) { o.IndexBackups = n }
+}
+
+// WithHashIgnoreSize makes Hash and Root depend only on names and digests,
+// leaving out each entry's recorded size, which the digest already implies.
+// Hashes then differ from those of stores without the option, so every
+// party comparing hashes (including saved hashes) must use the same setting.
+func WithHashIgnoreSize() OpenOption {
+	return func(o *OpenOptions) { o.HashIgnoreSize = true }
+}
+
+// WithVerifyAfterPush makes Push re-fetch the manifest after uploading and
+// check that its root label matches what was pushed, and that one of the new
+// content layers can be downloaded. It catches registries and caching proxies
+// that accept pushes without persisting them, at the cost of extra requests.
+func WithVerifyAfterPush() OpenOption {
+	return func(o *OpenOptions) { o.VerifyAfterPush = true }
+}
+
+// WithKeyValidator rejects keys for which fn returns an error, on top of the
+// built-in rules. Writes fail with that error; Get returns it, Stat and
+// Exists report the key as absent, and Delete ignores it.
+func WithKeyValidator(fn func(key string) error) OpenOption {
+	return func(o *OpenOptions) { o.KeyValidator = fn }
+}
+
+// WithKeyNormalizer rewrites every key passed to Put, Get, Stat, Delete and
+// the other key-taking methods before it is validated or used, e.g. to
+// lowercase hex IDs or collapse repeated slashes. fn runs once per call;
+// unless it is idempotent, keys from List don't round-trip through Get.
+// Keys already in the index, or pulled from a remote, are not rewritten.
+func WithKeyNormalizer(fn func(key string) string) OpenOption {
+	return func(o *OpenOptions) { o.KeyNormalizer = fn }
+}
+
+// WithPushTimestamps records the push time in each pushed image's
+// dev.cafs.created label, which PruneRemoteTags orders tags by. It is off by
+// default so identical content always yields an identical manifest; with
+// SOURCE_DATE_EPOCH set the label holds that time instead of the clock's.
+func WithPushTimestamps() OpenOption {
+	return func(o *OpenOptions) { o.PushTimestamps = true }
+}
+
 func defaultCacheDir() string {
 	if xdgData := os.Getenv("XDG_DATA_HOME"); xdgData != "" {
 		return filepath.Join(xdgData, "cafs")
@@ -0,0 +1,123 @@
+package cafs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+// legacyPrefixKey is the key prefix under which older versions kept prefix
+// hashes in the index itself, encoded as "hash|layer" in the digest field.
+const legacyPrefixKey = "_prefix/"
+
+// Prefix hashes record, per key prefix, the content hash and layer last pushed
+// to or pulled from the primary remote, so unchanged layers can be skipped.
+// They live beside the index rather than in it, so they never appear among
+// entries and never travel to the remote.
+func (s *CAS) prefixesPath() string {
+	return filepath.Join(s.cacheDir, s.namespace, s.tag+".prefixes.json")
+}
+
+func (s *CAS) loadPrefixHashes() map[string]remote.PrefixInfo {
+	result := make(map[string]remote.PrefixInfo)
+	s.prefixes.Range(func(k, v any) bool {
+		result[k.(string)] = v.(remote.PrefixInfo)
+		return true
+	})
+	return result
+}
+
+func (s *CAS) savePrefixHashes(prefixes map[string]remote.PrefixInfo) {
+	if len(prefixes) == 0 {
+		return
+	}
+	for prefix, info := range prefixes {
+		s.prefixes.Store(prefix, info)
+	}
+	s.dirty.Store(true)
+}
+
+// setPrefixHashes replaces all prefix hashes with prefixes, such as after a
+// push, which leaves the remote with exactly those.
+func (s *CAS) setPrefixHashes(prefixes map[string]remote.PrefixInfo) {
+	s.prefixes.Range(func(k, _ any) bool {
+		if _, ok := prefixes[k.(string)]; !ok {
+			s.prefixes.Delete(k)
+		}
+		return true
+	})
+	for prefix, info := range prefixes {
+		s.prefixes.Store(prefix, info)
+	}
+	s.dirty.Store(true)
+}
+
+// readPrefixesFile loads prefix hashes saved by writePrefixesFile.
+func (s *CAS) readPrefixesFile() error {
+	data, err := os.ReadFile(s.prefixesPath())
+	if err != nil {
+		return err
+	}
+	var m map[string]remote.PrefixInfo
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for prefix, info := range m {
+		s.prefixes.Store(prefix, info)
+	}
+	return nil
+}
+
+func (s *CAS) writePrefixesFile() error {
+	data, err := json.Marshal(s.loadPrefixHashes())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.prefixesPath(), data, 0644)
+}
+
+// extractLegacyPrefixes removes prefix hashes stored as index entries by
+// older versions from m and returns them. Every other reserved ("_") key is
+// dropped too, as is any legacy record that isn't exactly
+// "_prefix/<2 hex>" -> "<digest>|<digest>", so a crafted index can neither
+// inject entries users couldn't create nor corrupt push bookkeeping.
+func extractLegacyPrefixes(m map[string]Info) map[string]remote.PrefixInfo {
+	result := make(map[string]remote.PrefixInfo)
+	for key, info := range m {
+		if !strings.HasPrefix(key, "_") {
+			continue
+		}
+		delete(m, key)
+
+		prefix, ok := strings.CutPrefix(key, legacyPrefixKey)
+		if !ok || !isHex(prefix, 2) {
+			continue
+		}
+		hash, layer, ok := strings.Cut(string(info.Digest), "|")
+		if ok && isDigest(hash) && isDigest(layer) {
+			result[prefix] = remote.PrefixInfo{Hash: hash, Layer: layer}
+		}
+	}
+	return result
+}
+
+// isDigest reports whether s is "sha256:" followed by 64 lowercase hex digits.
+func isDigest(s string) bool {
+	hash, ok := strings.CutPrefix(s, digestPrefix)
+	return ok && isHex(hash, 64)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
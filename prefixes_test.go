@@ -0,0 +1,181 @@
+package cafs
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+func TestPrefixHashesStayOutOfKeyspace(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithRemote(newTestRegistry(t)+"/repo:main"))
+	keys := []string{"a|b", "x/_prefix/ab", "prefix|", "c.txt"}
+	for _, key := range keys {
+		mustPut(t, s, key, key)
+	}
+	if err := s.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if len(s.loadPrefixHashes()) == 0 {
+		t.Fatal("push recorded no prefix hashes")
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	index, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(index), `"`+legacyPrefixKey) {
+		t.Error("prefix hashes written into the index")
+	}
+
+	reopened := openTestStore(t, dir)
+	if got := slices.Sorted(maps.Keys(reopened.loadPrefixHashes())); !slices.Equal(got, slices.Sorted(maps.Keys(s.loadPrefixHashes()))) {
+		t.Errorf("prefixes after reopen = %v", got)
+	}
+	var listed []string
+	for key := range reopened.List("") {
+		listed = append(listed, key)
+	}
+	slices.Sort(listed)
+	slices.Sort(keys)
+	if !slices.Equal(listed, keys) || reopened.Len() != len(keys) {
+		t.Errorf("List = %v, Len = %d; want %v", listed, reopened.Len(), keys)
+	}
+}
+
+func TestExtractLegacyPrefixes(t *testing.T) {
+	hash := digestPrefix + strings.Repeat("a", 64)
+	layer := digestPrefix + strings.Repeat("b", 64)
+	m := map[string]Info{
+		"_prefix/ab":   {Digest: Digest(hash + "|" + layer)},
+		"_prefix/a|":   {Digest: Digest(hash + "|" + layer)},
+		"_prefix/cd":   {Digest: Digest(hash + "|" + layer + "|" + layer)},
+		"_prefix/ef":   {Digest: Digest(hash)},
+		"_other":       {Digest: Digest(hash)},
+		"a|b":          {Digest: Digest(hash)},
+		"x/_prefix/ab": {Digest: Digest(hash)},
+	}
+
+	got := extractLegacyPrefixes(m)
+	if len(got) != 1 || got["ab"].Hash != hash || got["ab"].Layer != layer {
+		t.Errorf("prefixes = %v, want only ab", got)
+	}
+	if keys := slices.Sorted(maps.Keys(m)); !slices.Equal(keys, []string{"a|b", "x/_prefix/ab"}) {
+		t.Errorf("entries left = %v", keys)
+	}
+}
+
+// craftedIndex is an index with reserved keys no client could have written.
+func craftedIndex(t *testing.T) []byte {
+	t.Helper()
+	hash := digestPrefix + strings.Repeat("a", 64)
+	layer := digestPrefix + strings.Repeat("b", 64)
+	content := string(computeDigest([]byte("ok")))
+	data, err := json.Marshal(map[string]serializedInfo{
+		"ok.txt":        {Digest: content, Size: 2, Inline: []byte("ok")},
+		"_prefix/ab":    {Digest: hash + "|" + layer},
+		"_prefix/zz":    {Digest: hash + "|" + layer},
+		"_prefix/cd":    {Digest: hash + "|" + layer + "|x"},
+		"_prefix/ef|gh": {Digest: hash + "|" + layer},
+		"_admin":        {Digest: content, Size: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCraftedIndexOnLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test", "main.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, craftedIndex(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestStore(t, dir)
+	var keys []string
+	for key := range s.List("") {
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []string{"ok.txt"}) {
+		t.Errorf("keys = %v, want only ok.txt", keys)
+	}
+	if got := slices.Sorted(maps.Keys(s.loadPrefixHashes())); !slices.Equal(got, []string{"ab"}) {
+		t.Errorf("migrated prefixes = %v, want [ab]", got)
+	}
+}
+
+func TestCraftedIndexOnPull(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+	pusher := newTestStore(t, WithRemote(ref))
+	data := craftedIndex(t)
+	root := string(computeDigest(data))
+	_, err := pusher.remote.Push(ctx, root, "", remote.FormatPlain, data, map[string][]byte{root: data}, nil)
+	if err != nil {
+		t.Fatalf("push crafted index: %v", err)
+	}
+
+	s := newTestStore(t, WithRemote(ref))
+	if err := s.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	var keys []string
+	for key := range s.List("") {
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []string{"ok.txt"}) {
+		t.Errorf("keys = %v, want only ok.txt", keys)
+	}
+	forged := digestPrefix + strings.Repeat("b", 64)
+	for prefix, info := range s.loadPrefixHashes() {
+		if info.Layer == forged {
+			t.Errorf("pulled index injected a record for prefix %q", prefix)
+		}
+	}
+}
+
+func TestPushRewritesPrefixesFromOlderVersions(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+	s := newTestStore(t, WithRemote(ref))
+	mustPut(t, s, "a", "alpha")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+
+	// Older versions recorded neither the layer size nor its diff ID.
+	for prefix, info := range s.loadPrefixHashes() {
+		s.prefixes.Store(prefix, remote.PrefixInfo{Hash: info.Hash, Layer: info.Layer})
+	}
+	mustPut(t, s, "b", "beta")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("second Push: %v", err)
+	}
+	for prefix, info := range s.loadPrefixHashes() {
+		if !info.Described() {
+			t.Errorf("prefix %s still has no layer descriptor", prefix)
+		}
+	}
+
+	fresh := newTestStore(t, WithRemote(ref))
+	if err := fresh.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := mustGet(t, fresh, "a"); got != "alpha" {
+		t.Errorf("a = %q, want %q", got, "alpha")
+	}
+}
@@ -0,0 +1,163 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func remoteTags(t *testing.T, s *CAS) []string {
+	t.Helper()
+	infos, err := s.remote.Tags(context.Background())
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	var tags []string
+	for _, info := range infos {
+		tags = append(tags, info.Tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+func TestPruneRemoteTagsKeepsNewest(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRegistry(t) + "/repo"
+
+	s := newTestStore(t, WithRemote(repo+":main"), WithPushTimestamps())
+	mustPut(t, s, "a", "1")
+	for i, tag := range []string{"main", "v1", "v2", "v3"} {
+		t.Setenv("SOURCE_DATE_EPOCH", strconv.Itoa(1000+i))
+		if err := s.Push(ctx, tag); err != nil {
+			t.Fatalf("Push %s: %v", tag, err)
+		}
+	}
+
+	// The store's own tag is neither deleted nor counted.
+	if err := s.PruneRemoteTags(ctx, 2); err != nil {
+		t.Fatalf("PruneRemoteTags: %v", err)
+	}
+	if got, want := remoteTags(t, s), []string{"main", "v2", "v3"}; !slices.Equal(got, want) {
+		t.Errorf("tags after prune = %v, want %v", got, want)
+	}
+	if err := s.PruneRemoteTags(ctx, 0); err != nil {
+		t.Fatalf("PruneRemoteTags(0): %v", err)
+	}
+	if got, want := remoteTags(t, s), []string{"main"}; !slices.Equal(got, want) {
+		t.Errorf("tags after prune = %v, want %v", got, want)
+	}
+}
+
+func TestPruneRemoteTagsKeepsTies(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRegistry(t) + "/repo"
+
+	// A pinned SOURCE_DATE_EPOCH makes every tag tie, so there is nothing to
+	// prune.
+	t.Setenv("SOURCE_DATE_EPOCH", "1000")
+	s := newTestStore(t, WithRemote(repo+":main"), WithPushTimestamps())
+	mustPut(t, s, "a", "1")
+	for _, tag := range []string{"a", "b", "c"} {
+		if err := s.Push(ctx, tag); err != nil {
+			t.Fatalf("Push %s: %v", tag, err)
+		}
+	}
+	if err := s.PruneRemoteTags(ctx, 1); err != nil {
+		t.Fatalf("PruneRemoteTags: %v", err)
+	}
+	if got, want := remoteTags(t, s), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("tags after prune = %v, want %v", got, want)
+	}
+}
+
+func TestPruneRemoteTagsNeedsTimestamps(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRegistry(t) + "/repo"
+
+	s := newTestStore(t, WithRemote(repo+":main"))
+	mustPut(t, s, "a", "1")
+	for _, tag := range []string{"a", "b", "c"} {
+		if err := s.Push(ctx, tag); err != nil {
+			t.Fatalf("Push %s: %v", tag, err)
+		}
+	}
+	if err := s.PruneRemoteTags(ctx, 1); !errors.Is(err, ErrNoTimestamps) {
+		t.Fatalf("PruneRemoteTags: err = %v, want ErrNoTimestamps", err)
+	}
+	if got, want := remoteTags(t, s), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("tags after failed prune = %v, want %v", got, want)
+	}
+}
+
+func TestPruneRemoteTagsDeleteUnsupported(t *testing.T) {
+	ctx := context.Background()
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/repo"
+
+	s := newTestStore(t, WithRemote(repo+":main"))
+	mustPut(t, s, "a", "1")
+	if err := s.Push(ctx, "old"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if err := s.PruneRemoteTags(ctx, 0); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Errorf("PruneRemoteTags: err = %v, want ErrDeleteUnsupported", err)
+	}
+}
+
+func TestTagsSkipsForeignImages(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRegistry(t) + "/repo"
+
+	s := newTestStore(t, WithRemote(repo+":main"))
+	mustPut(t, s, "a", "1")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	// A plain image and an image index share the repository.
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := name.ParseReference(repo + ":plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ggcrremote.Write(plain, img); err != nil {
+		t.Fatalf("write image: %v", err)
+	}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: img})
+	index, err := name.ParseReference(repo + ":index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ggcrremote.WriteIndex(index, idx); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+
+	if got, want := remoteTags(t, s), []string{"main"}; !slices.Equal(got, want) {
+		t.Errorf("Tags = %v, want %v", got, want)
+	}
+}
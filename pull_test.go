@@ -0,0 +1,80 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPullStrategy(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	src := newTestStore(t, WithRemote(ref))
+	mustPut(t, src, "k", "theirs")
+	mustPut(t, src, "only-remote", "r")
+	if err := src.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	tests := []struct {
+		strategy string
+		want     string
+		conflict bool
+	}{
+		{PullTheirsWins, "theirs", false},
+		{PullOursWins, "ours", false},
+		{PullErrorOnConflict, "ours", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			s := newTestStore(t, WithRemote(ref), WithPullStrategy(tt.strategy))
+			mustPut(t, s, "k", "ours")
+
+			err := s.Pull(ctx)
+			var conflict *ConflictError
+			if got := errors.As(err, &conflict); got != tt.conflict {
+				t.Fatalf("Pull = %v, conflict %v, want %v", err, got, tt.conflict)
+			}
+			if got := mustGet(t, s, "k"); got != tt.want {
+				t.Errorf("k = %q, want %q", got, tt.want)
+			}
+			if tt.conflict {
+				if len(conflict.Keys) != 1 || conflict.Keys[0] != "k" {
+					t.Errorf("conflict keys = %v, want [k]", conflict.Keys)
+				}
+				return
+			}
+			if got := mustGet(t, s, "only-remote"); got != "r" {
+				t.Errorf("only-remote = %q, want %q", got, "r")
+			}
+		})
+	}
+}
+
+func TestPullFallsBackAndMirrorPush(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestRegistry(t) + "/repo:main"
+	mirror := newTestRegistry(t) + "/repo:main"
+
+	src := newTestStore(t, WithRemotes(primary, mirror), WithMirrorPush())
+	mustPut(t, src, "k", "v")
+	if err := src.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	// Only the mirror has the image under this tag.
+	other := newTestRegistry(t) + "/repo:main"
+	s := newTestStore(t, WithRemotes(other, mirror))
+	if err := s.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := mustGet(t, s, "k"); got != "v" {
+		t.Errorf("k = %q, want %q", got, "v")
+	}
+
+	none := newTestStore(t, WithRemotes(other, newTestRegistry(t)+"/repo:main"))
+	if err := none.Pull(ctx); err == nil {
+		t.Error("Pull succeeded with no remote holding the image")
+	}
+}
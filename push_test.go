@@ -0,0 +1,216 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aweris/cafs/internal/remote"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPushIndexInLayerOnly(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	s := newTestStore(t, WithRemote(ref), WithIndexInLayerOnly())
+	mustPut(t, s, "a.txt", "hello")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	res, err := s.remote.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("remote Pull: %v", err)
+	}
+	if res.Index == nil {
+		t.Fatal("index layer missing")
+	}
+	for hash := range res.Objects {
+		if normalizeDigest(hash) == normalizeDigest(res.Root) {
+			t.Errorf("index %s also pushed as a content blob", res.Root)
+		}
+	}
+
+	fresh := newTestStore(t, WithRemote(ref))
+	if err := fresh.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := mustGet(t, fresh, "a.txt"); got != "hello" {
+		t.Errorf("a.txt = %q, want %q", got, "hello")
+	}
+}
+
+func TestPushIndexAmongContentByDefault(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	s := newTestStore(t, WithRemote(ref))
+	mustPut(t, s, "a.txt", "hello")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	res, err := s.remote.Pull(ctx, nil)
+	if err != nil {
+		t.Fatalf("remote Pull: %v", err)
+	}
+	found := false
+	for hash := range res.Objects {
+		found = found || normalizeDigest(hash) == normalizeDigest(res.Root)
+	}
+	if !found {
+		t.Error("index not among content blobs, older clients can't find it")
+	}
+}
+
+func manifestDigest(t *testing.T, ref string) string {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := ggcrremote.Head(r)
+	if err != nil {
+		t.Fatalf("Head %s: %v", ref, err)
+	}
+	return desc.Digest.String()
+}
+
+func TestPushIsReproducible(t *testing.T) {
+	ctx := context.Background()
+	pushTo := func(ref string, opts ...OpenOption) string {
+		s := newTestStore(t, append(opts, WithRemote(ref))...)
+		mustPut(t, s, "a", "1")
+		mustPut(t, s, "dir/b", "2")
+		if err := s.Push(ctx); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		return manifestDigest(t, ref)
+	}
+
+	first := pushTo(newTestRegistry(t) + "/repo:main")
+	time.Sleep(1100 * time.Millisecond) // a wall-clock timestamp would now differ
+	if second := pushTo(newTestRegistry(t) + "/repo:main"); second != first {
+		t.Errorf("same content pushed as %s and %s", first, second)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	first = pushTo(newTestRegistry(t)+"/repo:main", WithPushTimestamps())
+	time.Sleep(1100 * time.Millisecond)
+	if second := pushTo(newTestRegistry(t)+"/repo:main", WithPushTimestamps()); second != first {
+		t.Errorf("pinned timestamps pushed as %s and %s", first, second)
+	}
+}
+
+func TestVerifyAfterPush(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithRemote(newTestRegistry(t)+"/repo:main"), WithVerifyAfterPush())
+	mustPut(t, s, "a.txt", "hello")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestVerifyAfterPushCatchesLostWrites(t *testing.T) {
+	ctx := context.Background()
+	// A registry that acknowledges manifests without keeping them.
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/repo:main"
+
+	s := newTestStore(t, WithRemote(ref), WithVerifyAfterPush())
+	mustPut(t, s, "a.txt", "hello")
+	info, _ := s.Stat("a.txt")
+	if err := s.Push(ctx); !errors.Is(err, ErrPushNotPersisted) {
+		t.Fatalf("Push: err = %v, want ErrPushNotPersisted", err)
+	}
+	if !isPending(s, info.Digest) {
+		t.Error("blob left the pending set after an unverified push")
+	}
+
+	unverified := newTestStore(t, WithRemote(ref))
+	mustPut(t, unverified, "a.txt", "hello")
+	if err := unverified.Push(ctx); err != nil {
+		t.Errorf("Push without verification: %v", err)
+	}
+}
+
+// samePrefixValues returns n distinct values whose blobs share a prefix, and
+// so a layer.
+func samePrefixValues(n int) []string {
+	var values []string
+	var prefix string
+	for i := 0; len(values) < n; i++ {
+		v := fmt.Sprintf("value-%d", i)
+		p := remote.PrefixOf(string(computeDigest([]byte(v))))
+		if prefix == "" {
+			prefix = p
+		}
+		if p == prefix {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func TestIncrementalPushKeepsEarlierContent(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+	v := samePrefixValues(2)
+
+	var reports []TransferReport
+	s := newTestStore(t, WithRemote(ref), WithTransferHook(func(r TransferReport) {
+		reports = append(reports, r)
+	}))
+	mustPut(t, s, "a", "alpha")
+	mustPut(t, s, "same-1", v[0])
+	mustPut(t, s, "gone", "deleted later")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+	mustPut(t, s, "b", "beta")
+	mustPut(t, s, "same-2", v[1])
+	s.Delete("gone")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("second Push: %v", err)
+	}
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("third Push: %v", err)
+	}
+	if got := reports[2].Layers; got != 1 {
+		t.Errorf("push without changes uploaded %d layers, want only the index", got)
+	}
+
+	fresh := newTestStore(t, WithRemote(ref))
+	if err := fresh.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	missing, err := fresh.ValidateComplete()
+	if err != nil || len(missing) > 0 {
+		t.Fatalf("ValidateComplete = %v, %v; want nothing missing", missing, err)
+	}
+	for key, want := range map[string]string{"a": "alpha", "b": "beta", "same-1": v[0], "same-2": v[1]} {
+		if got := mustGet(t, fresh, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := fresh.Stat("gone"); ok {
+		t.Error("deleted key came back")
+	}
+}
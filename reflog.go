@@ -0,0 +1,70 @@
+package cafs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// reflogEntry records a root that the local tag pushed to or pulled from a
+// remote tag.
+type reflogEntry struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Root   Digest    `json:"root"`
+	Remote string    `json:"remote,omitempty"`
+}
+
+func (s *CAS) reflogPath() string {
+	return filepath.Join(s.cacheDir, s.namespace, s.tag+".reflog")
+}
+
+// appendReflog adds an entry to the local tag's ref-log, one JSON object per
+// line. Every root the local index held is logged here, whichever remote tag
+// it went to, so OpenAtTime can find it.
+func (s *CAS) appendReflog(op, remoteTag string, root Digest) error {
+	path := s.reflogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(reflogEntry{Time: time.Now().UTC(), Op: op, Root: root, Remote: remoteTag})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readReflog returns the entries of a ref-log in the order they were written.
+// A missing file is an empty log; malformed lines are skipped.
+func readReflog(path string) ([]reflogEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []reflogEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e reflogEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Root != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,63 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOpenAtTime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithRemote(newTestRegistry(t)+"/repo:main"))
+
+	var marks []time.Time
+	for i, content := range []string{"one", "two", "three"} {
+		mustPut(t, s, "a.txt", content)
+		tags := []string{"main"}
+		if i == 2 {
+			tags = []string{"other"} // logged to the local tag all the same
+		}
+		if err := s.Push(ctx, tags...); err != nil {
+			t.Fatalf("Push %d: %v", i, err)
+		}
+		marks = append(marks, time.Now())
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{marks[0], "one"},
+		{marks[1], "two"},
+		{marks[2], "three"},
+		{marks[2].Add(time.Hour), "three"},
+	}
+	for _, tt := range tests {
+		old, err := OpenAtTime("test:main", tt.at, WithCacheDir(dir))
+		if err != nil {
+			t.Fatalf("OpenAtTime(%s): %v", tt.at, err)
+		}
+		if got := mustGet(t, old, "a.txt"); got != tt.want {
+			t.Errorf("OpenAtTime(%s): a.txt = %q, want %q", tt.at, got, tt.want)
+		}
+		if err := old.Put("b.txt", nil); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Put on snapshot: err = %v, want ErrReadOnly", err)
+		}
+		old.Close()
+	}
+
+	entries, err := readReflog(s.reflogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Remote != "other" {
+		t.Errorf("ref-log = %+v, want 3 entries, the last for tag other", entries)
+	}
+
+	_, err = OpenAtTime("test:main", marks[0].Add(-time.Hour), WithCacheDir(dir))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("before first push: err = %v, want ErrNotFound", err)
+	}
+}
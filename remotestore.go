@@ -0,0 +1,182 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+// lazyLayers fetches content layers of a remote image on first use.
+type lazyLayers struct {
+	layers  map[string]string // prefix -> layer digest, fixed after open
+	tmpDir  string
+	timeout time.Duration // per layer fetch, 0 = none
+
+	mu       sync.Mutex
+	inflight map[string]*layerFetch // layer digest -> fetch in progress
+}
+
+// layerFetch lets concurrent reads of one layer share a single download.
+type layerFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// OpenRemote opens a read-only store backed directly by the OCI image at ref.
+// Only the index is pulled and kept in memory; no local index file is
+// written. Blob content is fetched layer by layer on first read and cached in
+// a temporary directory that Close removes. WithOpenTimeout bounds the initial
+// index fetch and each layer fetch made by a read.
+func OpenRemote(ref string, opts ...OpenOption) (Store, error) {
+	tmpDir, err := os.MkdirTemp("", "cafs-remote-*")
+	if err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	s, err := openRemote(ref, tmpDir, opts)
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	return s, nil
+}
+
+func openRemote(ref, tmpDir string, opts []OpenOption) (*CAS, error) {
+	opts = append(opts, WithCacheDir(tmpDir), WithRemote(ref))
+	s, options, err := newStore("remote", opts)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+	s.audit.close()
+	s.audit = nil
+	s.lazy = &lazyLayers{
+		layers:   make(map[string]string),
+		tmpDir:   tmpDir,
+		timeout:  options.OpenTimeout,
+		inflight: make(map[string]*layerFetch),
+	}
+
+	ctx := context.Background()
+	if options.OpenTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.OpenTimeout)
+		defer cancel()
+	}
+
+	head, err := s.remote.PullIndex(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pull index: %w", err)
+	}
+	for prefix, info := range head.Prefixes {
+		s.lazy.layers[prefix] = info.Layer
+	}
+
+	root := normalizeDigest(head.Root)
+	indexData := head.Index
+	if indexData == nil {
+		// Older images carry the index among the content blobs
+		if err := s.fetchLayerFor(ctx, root); err != nil {
+			return nil, fmt.Errorf("load index: %w", err)
+		}
+		if indexData, err = s.blobs.Get(root); err != nil {
+			return nil, fmt.Errorf("load index: %w", err)
+		}
+	}
+
+	entries, err := s.decodeIndex(indexData)
+	if err != nil {
+		return nil, fmt.Errorf("parse index: %w", err)
+	}
+	extractLegacyPrefixes(entries)
+	for key, info := range entries {
+		s.setEntry(key, info)
+	}
+	return s, nil
+}
+
+// fetchLayerFor downloads the layer holding digest and stores all of its
+// blobs, so neighbouring reads are served locally. Reads of a layer that is
+// already being fetched wait for that fetch; other layers download in
+// parallel.
+func (s *CAS) fetchLayerFor(ctx context.Context, digest Digest) error {
+	layer, ok := s.lazy.layers[remote.PrefixOf(string(digest))]
+	if !ok {
+		return fmt.Errorf("no layer for %s: %w", digest, ErrNotFound)
+	}
+
+	s.lazy.mu.Lock()
+	if s.blobs.Has(digest) {
+		s.lazy.mu.Unlock()
+		return nil // fetched while we waited
+	}
+	if f, ok := s.lazy.inflight[layer]; ok {
+		s.lazy.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &layerFetch{done: make(chan struct{})}
+	s.lazy.inflight[layer] = f
+	s.lazy.mu.Unlock()
+
+	f.err = s.fetchLayer(ctx, layer)
+	s.lazy.mu.Lock()
+	delete(s.lazy.inflight, layer)
+	s.lazy.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+func (s *CAS) fetchLayer(ctx context.Context, layer string) error {
+	blobs, err := s.remote.FetchLayer(ctx, layer)
+	if err != nil {
+		return err
+	}
+	for hash, data := range blobs {
+		digest := normalizeDigest(hash)
+		if computeDigest(data) != digest {
+			continue // never cache content that doesn't match its address
+		}
+		if _, err := s.blobs.putWithDigest(digest, data); err != nil {
+			return fmt.Errorf("store blob %s: %w", hash, err)
+		}
+	}
+	return nil
+}
+
+// readLazy returns a blob, fetching its layer from the remote if needed.
+func (s *CAS) readLazy(digest Digest) ([]byte, error) {
+	data, err := s.blobs.Get(digest)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+	if list, ok := s.chunks.Load(digest); ok {
+		for _, d := range list.([]Digest) {
+			if _, err := s.readLazy(d); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.reassemble(digest); err != nil {
+			return nil, err
+		}
+		return s.blobs.Get(digest)
+	}
+	ctx := s.bgCtx
+	if s.lazy.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.lazy.timeout)
+		defer cancel()
+	}
+	if err := s.fetchLayerFor(ctx, digest); err != nil {
+		return nil, err
+	}
+	return s.blobs.Get(digest)
+}
@@ -0,0 +1,182 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+// newCountingRegistry is newTestRegistry, also counting blob downloads.
+func newCountingRegistry(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	var downloads atomic.Int64
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/sha256:") {
+			downloads.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &downloads
+}
+
+func pushTestContent(t *testing.T, ref string, files map[string]string) {
+	t.Helper()
+	s := newTestStore(t, WithRemote(ref))
+	for key, data := range files {
+		mustPut(t, s, key, data)
+	}
+	if err := s.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestOpenRemote(t *testing.T) {
+	host, downloads := newCountingRegistry(t)
+	ref := host + "/repo:main"
+	pushTestContent(t, ref, map[string]string{"a.txt": "alpha", "b/c.txt": "gamma"})
+
+	store, err := OpenRemote(ref)
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+	s := store.(*CAS)
+	tmpDir := s.lazy.tmpDir
+
+	if s.Len() != 2 {
+		t.Errorf("Len = %d, want 2", s.Len())
+	}
+	opened := downloads.Load()
+	if got := mustGet(t, s, "a.txt"); got != "alpha" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := mustGet(t, s, "b/c.txt"); got != "gamma" {
+		t.Errorf("b/c.txt = %q", got)
+	}
+	if downloads.Load() == opened {
+		t.Error("reads didn't fetch any layers; content was pulled at open")
+	}
+	if err := s.Put("d.txt", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put: err = %v, want ErrReadOnly", err)
+	}
+
+	err = filepath.WalkDir(tmpDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !strings.Contains(path, "blobs") {
+			t.Errorf("wrote %s outside the blob cache", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Errorf("Close left %s behind (stat err %v)", tmpDir, err)
+	}
+}
+
+func TestOpenRemoteAfterIncrementalPush(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+	s := newTestStore(t, WithRemote(ref))
+	mustPut(t, s, "a.txt", "alpha")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+	mustPut(t, s, "b.txt", "beta")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("second Push: %v", err)
+	}
+
+	store, err := OpenRemote(ref)
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if got := mustGet(t, store, "a.txt"); got != "alpha" {
+		t.Errorf("a.txt = %q, want %q", got, "alpha")
+	}
+	if got := mustGet(t, store, "b.txt"); got != "beta" {
+		t.Errorf("b.txt = %q, want %q", got, "beta")
+	}
+}
+
+func TestOpenRemoteSharesLayerFetches(t *testing.T) {
+	host, downloads := newCountingRegistry(t)
+	ref := host + "/repo:main"
+	pushTestContent(t, ref, map[string]string{"a.txt": "alpha"})
+
+	store, err := OpenRemote(ref)
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	opened := downloads.Load()
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			if data, err := store.Get("a.txt"); err != nil || string(data) != "alpha" {
+				t.Errorf("Get = %q, %v", data, err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := downloads.Load() - opened; n != 1 {
+		t.Errorf("%d layer downloads for concurrent reads of one layer, want 1", n)
+	}
+}
+
+func TestOpenRemoteTimeoutBoundsReads(t *testing.T) {
+	var stall atomic.Bool
+	release := make(chan struct{})
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stall.Load() && strings.Contains(r.URL.Path, "/blobs/") {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/repo:main"
+	pushTestContent(t, ref, map[string]string{"a.txt": "alpha"})
+
+	store, err := OpenRemote(ref, WithOpenTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	stall.Store(true)
+	start := time.Now()
+	if _, err := store.Get("a.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get from a stalled remote: err = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get took %v with a 200ms open timeout", elapsed)
+	}
+}
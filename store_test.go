@@ -0,0 +1,413 @@
+package cafs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHasBlob(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "content on disk")
+	info, _ := s.Stat("a")
+	if !s.HasBlob(info.Digest) {
+		t.Errorf("HasBlob(%s) = false after Put", info.Digest)
+	}
+	if s.HasBlob(computeDigest([]byte("never stored"))) {
+		t.Error("HasBlob reports content that was never stored")
+	}
+
+	inline := newTestStore(t, WithInlineThreshold(64))
+	mustPut(t, inline, "small", "tiny")
+	info, _ = inline.Stat("small")
+	if !inline.HasBlob(info.Digest) {
+		t.Errorf("HasBlob(%s) = false for inline content", info.Digest)
+	}
+}
+
+func TestKeysForDigest(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "b", "same")
+	mustPut(t, s, "a", "same")
+	mustPut(t, s, "c", "other")
+
+	info, _ := s.Stat("a")
+	if got := s.KeysForDigest(info.Digest); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("KeysForDigest = %v, want [a b]", got)
+	}
+	if got := s.KeysForDigest(computeDigest([]byte("missing"))); len(got) != 0 {
+		t.Errorf("KeysForDigest of unknown content = %v", got)
+	}
+}
+
+func TestStatMulti(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "1")
+	mustPut(t, s, "b", "2")
+
+	got := s.StatMulti([]string{"a", "b", "missing"})
+	if len(got) != 2 {
+		t.Fatalf("StatMulti returned %d entries, want 2", len(got))
+	}
+	if got["a"].Digest != computeDigest([]byte("1")) {
+		t.Errorf("a = %+v", got["a"])
+	}
+}
+
+func TestStatMultiSeesWholeTransactions(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "0")
+	mustPut(t, s, "b", "0")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			tx := s.Begin()
+			v := []byte(strconv.Itoa(i))
+			_ = tx.Put("a", v)
+			_ = tx.Put("b", v)
+			if err := tx.Commit(); err != nil {
+				t.Errorf("Commit: %v", err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		got := s.StatMulti([]string{"a", "b"})
+		if got["a"].Digest != got["b"].Digest {
+			t.Fatalf("StatMulti saw a half-applied transaction: %+v", got)
+		}
+	}
+}
+
+func TestDigestIsPlainSHA256(t *testing.T) {
+	s := newTestStore(t)
+	data := "the one content hash"
+	mustPut(t, s, "k", data)
+	if err := s.PutReader("r", strings.NewReader(data)); err != nil {
+		t.Fatalf("PutReader: %v", err)
+	}
+
+	h := sha256.Sum256([]byte(data))
+	want := Digest("sha256:" + hex.EncodeToString(h[:]))
+	for _, key := range []string{"k", "r"} {
+		if info, _ := s.Stat(key); info.Digest != want {
+			t.Errorf("%s digest = %s, want %s", key, info.Digest, want)
+		}
+	}
+
+	// SyncToDir's on-disk comparison hashes the same way.
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fileDigest(path); err != nil || got != want {
+		t.Errorf("fileDigest = %s, %v; want %s", got, err, want)
+	}
+}
+
+func TestInlineThreshold(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, WithInlineThreshold(16))
+	mustPut(t, s, "small", "tiny")
+	mustPut(t, s, "large", strings.Repeat("x", 64))
+
+	small, _ := s.Stat("small")
+	if _, err := os.Stat(s.Path(small.Digest)); !os.IsNotExist(err) {
+		t.Errorf("inline content has a blob file (stat err %v)", err)
+	}
+	large, _ := s.Stat("large")
+	if _, err := os.Stat(s.Path(large.Digest)); err != nil {
+		t.Errorf("large content has no blob file: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Inline content travels in the index.
+	reopened := openTestStore(t, dir)
+	if got := mustGet(t, reopened, "small"); got != "tiny" {
+		t.Errorf("small = %q after reopen", got)
+	}
+}
+
+func TestStatsReportsBlobSizes(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "12345")
+	mustPut(t, s, "b", "12345") // same blob
+	mustPut(t, s, "c", "123")
+
+	st := s.Stats()
+	if st.Entries != 3 || st.Blobs != 2 || st.TotalSize != 8 {
+		t.Errorf("Stats = %+v, want 3 entries, 2 blobs, 8 bytes", st)
+	}
+
+	s.Delete("c")
+	if removed, err := s.GC(); err != nil || removed != 1 {
+		t.Errorf("GC = %d, %v; want 1 blob removed", removed, err)
+	}
+	if st := s.Stats(); st.Blobs != 1 || st.TotalSize != 5 {
+		t.Errorf("Stats after GC = %+v", st)
+	}
+}
+
+func TestGetReadsThroughToDisk(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "k", "before")
+	info, _ := s.Stat("k")
+	if got := mustGet(t, s, "k"); got != "before" {
+		t.Fatalf("k = %q", got)
+	}
+
+	// Nothing is cached in memory: a read after the file changes sees it.
+	path := s.Path(info.Digest)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("after!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, s, "k"); got != "after!" {
+		t.Errorf("k = %q, want the on-disk content", got)
+	}
+}
+
+func TestIdenticalPutKeepsIndexClean(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "k", "v")
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	mustPut(t, s, "k", "v")
+	if s.Dirty() {
+		t.Error("rewriting identical content dirtied the index")
+	}
+	if err := s.Put("k", []byte("v"), WithMeta(map[string]any{"x": 1})); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Dirty() {
+		t.Error("changing metadata left the index clean")
+	}
+}
+
+func TestVerifyReportsMissingAndCorruptBlobs(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "ok", "fine")
+	mustPut(t, s, "missing", "gone")
+	mustPut(t, s, "corrupt", "flipped")
+
+	if bad, err := s.Verify(context.Background()); err != nil || len(bad) != 0 {
+		t.Fatalf("Verify on a healthy store = %v, %v", bad, err)
+	}
+
+	missing, _ := s.Stat("missing")
+	corrupt, _ := s.Stat("corrupt")
+	if err := os.Remove(s.Path(missing.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	path := s.Path(corrupt.Digest)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("flopped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bad, err := s.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := []Digest{missing.Digest, corrupt.Digest}
+	slices.Sort(want)
+	if !slices.Equal(bad, want) {
+		t.Errorf("Verify = %v, want %v", bad, want)
+	}
+}
+
+// patternReader yields n bytes of a fixed pattern without holding them.
+type patternReader struct{ off, n int64 }
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off >= r.n {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.n-r.off)]
+	for i := range p {
+		p[i] = byte((r.off + int64(i)) % 251)
+	}
+	r.off += int64(len(p))
+	return len(p), nil
+}
+
+func TestPutBase64Stream(t *testing.T) {
+	const size = 32 << 20
+	s := newTestStore(t)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, &patternReader{n: size}); err != nil {
+		t.Fatal(err)
+	}
+	want := Digest(digestPrefix + hex.EncodeToString(h.Sum(nil)))
+
+	pr, pw := io.Pipe()
+	go func() {
+		enc := base64.NewEncoder(base64.StdEncoding, pw)
+		_, err := io.Copy(enc, &patternReader{n: size})
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := s.PutBase64Stream("big", pr); err != nil {
+		t.Fatalf("PutBase64Stream: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	info, ok := s.Stat("big")
+	if !ok {
+		t.Fatal("big not stored")
+	}
+	if info.Digest != want || info.Size != size {
+		t.Errorf("stored %s (%d bytes), want %s (%d bytes)", info.Digest, info.Size, want, size)
+	}
+	// The encoder goroutine allocates too, but nowhere near the body size.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/4 {
+		t.Errorf("allocated %d bytes for a %d byte body", alloc, size)
+	}
+}
+
+func TestPutBase64StreamRejectsBadInput(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.PutBase64Stream("k", strings.NewReader("not base64!")); err == nil {
+		t.Fatal("malformed base64 accepted")
+	}
+	if _, ok := s.Stat("k"); ok {
+		t.Error("malformed body left an entry")
+	}
+}
+
+func TestGetDetectsSizeMismatch(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "k", "12345")
+	info, _ := s.Stat("k")
+
+	// An index that disagrees with the blob, as a partial pull may leave.
+	info.Size = 3
+	s.setEntry("k", info)
+	_, err := s.Get("k")
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("Get: err = %v, want ErrSizeMismatch", err)
+	}
+	if !strings.Contains(err.Error(), "5 bytes") || !strings.Contains(err.Error(), "records 3") {
+		t.Errorf("error %q doesn't name both sizes", err)
+	}
+}
+
+func TestValidateComplete(t *testing.T) {
+	s := newTestStore(t, WithInlineThreshold(4))
+	mustPut(t, s, "a", "shared content")
+	mustPut(t, s, "also-a", "shared content")
+	mustPut(t, s, "b", "other content")
+	mustPut(t, s, "c", "corrupt content")
+	mustPut(t, s, "tiny", "in")
+	if err := s.MkdirEntry("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := s.ValidateComplete(); err != nil || len(missing) != 0 {
+		t.Fatalf("ValidateComplete on a complete store = %v, %v", missing, err)
+	}
+
+	a, _ := s.Stat("a")
+	c, _ := s.Stat("c")
+	if err := os.Remove(s.Path(a.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	// Presence only: corrupt content isn't ValidateComplete's business.
+	if err := os.Chmod(s.Path(c.Digest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.Path(c.Digest), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := s.ValidateComplete()
+	if err != nil {
+		t.Fatalf("ValidateComplete: %v", err)
+	}
+	if want := []string{"a", "also-a"}; !slices.Equal(missing, want) {
+		t.Errorf("ValidateComplete = %v, want %v", missing, want)
+	}
+}
+
+func TestDigestOfMatchesPut(t *testing.T) {
+	s := newTestStore(t, WithInlineThreshold(8))
+	for _, data := range []string{"", "tiny", "content large enough for a blob file"} {
+		want := DigestOf([]byte(data))
+		digest, n, err := DigestOfReader(strings.NewReader(data))
+		if err != nil || digest != want || n != int64(len(data)) {
+			t.Errorf("DigestOfReader(%q) = %s, %d, %v; want %s, %d", data, digest, n, err, want, len(data))
+		}
+		if s.HasBlob(want) {
+			t.Errorf("HasBlob(%s) before Put", want)
+		}
+
+		mustPut(t, s, "k", data)
+		if info, _ := s.Stat("k"); info.Digest != want {
+			t.Errorf("Put(%q) stored %s, DigestOf gave %s", data, info.Digest, want)
+		}
+		if !s.HasBlob(want) {
+			t.Errorf("HasBlob(%s) after Put", want)
+		}
+	}
+}
+
+func TestHashIgnoreSize(t *testing.T) {
+	for _, ignore := range []bool{false, true} {
+		var opts []OpenOption
+		if ignore {
+			opts = append(opts, WithHashIgnoreSize())
+		}
+		s := newTestStore(t, opts...)
+		mustPut(t, s, "dir/a", "content")
+		mustPut(t, s, "dir/b", "more content")
+		hash, root := s.Hash("dir/"), s.Root()
+
+		// Same content, stale recorded size.
+		info, _ := s.Stat("dir/a")
+		info.Size++
+		s.setEntry("dir/a", info)
+
+		if stable := s.Hash("dir/") == hash && s.Root() == root; stable != ignore {
+			t.Errorf("ignore size %v: hashes stable across a size change = %v", ignore, stable)
+		}
+	}
+
+	plain := newTestStore(t)
+	ignoring := newTestStore(t, WithHashIgnoreSize())
+	for _, s := range []*CAS{plain, ignoring} {
+		mustPut(t, s, "a", "same")
+	}
+	if plain.Root() == ignoring.Root() {
+		t.Error("hashes with and without WithHashIgnoreSize agree; the option changes nothing")
+	}
+}
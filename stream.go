@@ -0,0 +1,159 @@
+package cafs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Stream format: one record per entry, in key order, with no header:
+//
+//	[uint32 key length][key][uint64 content size][content]
+//
+// Integers are big-endian. Directory entries (keys ending in "/") have no
+// content.
+
+// StreamOut writes every entry under prefix to w in the stream format, keyed
+// by its full key. Content is copied from disk as w accepts it.
+func (s *CAS) StreamOut(w io.Writer, prefix string) error {
+	var keys []string
+	infos := make(map[string]Info)
+	for rel, info := range s.List(prefix) {
+		keys = append(keys, prefix+rel)
+		infos[prefix+rel] = info
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	var hdr [8]byte
+	for _, key := range keys {
+		info := infos[key]
+		binary.BigEndian.PutUint32(hdr[:4], uint32(len(key)))
+		if _, err := bw.Write(hdr[:4]); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(key); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(hdr[:], uint64(info.Size))
+		if _, err := bw.Write(hdr[:]); err != nil {
+			return err
+		}
+		if err := s.copyBlob(bw, info); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// copyBlob writes exactly info.Size bytes of the entry's content to w.
+func (s *CAS) copyBlob(w io.Writer, info Info) error {
+	var r io.Reader
+	if v, ok := s.inline.Load(info.Digest); ok {
+		r = bytes.NewReader(v.([]byte))
+	} else if s.lazy != nil {
+		data, err := s.readBlob(info.Digest)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		f, err := os.Open(s.blobs.blobPath(info.Digest))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := io.CopyN(w, r, info.Size)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %d bytes, index records %d", ErrSizeMismatch, n, info.Size)
+	}
+	return err
+}
+
+// StreamIn stores every record read from r, as written by StreamOut, and
+// returns the number of entries stored. It stops at the first malformed or
+// truncated record.
+func (s *CAS) StreamIn(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	count := 0
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:4]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return count, nil
+			}
+			return count, fmt.Errorf("record %d: %w", count, err)
+		}
+		keyLen := binary.BigEndian.Uint32(hdr[:4])
+		if keyLen == 0 || keyLen > maxKeyLength+1 {
+			return count, fmt.Errorf("record %d: key length %d: %w", count, keyLen, ErrInvalidKey)
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(br, key); err != nil {
+			return count, fmt.Errorf("record %d: %w", count, unexpectedEOF(err))
+		}
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return count, fmt.Errorf("record %d: %w", count, unexpectedEOF(err))
+		}
+		size := binary.BigEndian.Uint64(hdr[:])
+		if size > 1<<62 {
+			return count, fmt.Errorf("record %d: size %d out of range", count, size)
+		}
+
+		if err := s.streamEntry(string(key), br, int64(size)); err != nil {
+			return count, fmt.Errorf("record %d (%s): %w", count, key, err)
+		}
+		count++
+	}
+}
+
+func (s *CAS) streamEntry(key string, r io.Reader, size int64) error {
+	if IsDirKey(key) {
+		if size != 0 {
+			return fmt.Errorf("directory entry with %d bytes of content", size)
+		}
+		return s.MkdirEntry(key)
+	}
+	if size <= int64(s.inlineThreshold) {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return unexpectedEOF(err)
+		}
+		return s.Put(key, data)
+	}
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := s.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if err := validateFileKey(key); err != nil {
+		return err
+	}
+	// Check the length before touching the entry, so a truncated stream
+	// never replaces it
+	digest, n, err := s.blobs.PutReader(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return io.ErrUnexpectedEOF
+	}
+	return s.storeEntry(key, Info{Digest: digest, Size: n})
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
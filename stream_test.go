@@ -0,0 +1,140 @@
+package cafs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func streamTestStore(t testing.TB) *CAS {
+	s, err := Open("test:main", WithCacheDir(t.TempDir()), WithInlineThreshold(8))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s.(*CAS)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	src := newTestStore(t)
+	files := map[string]string{
+		"src/main.go":     "package main\n",
+		"src/empty":       "",
+		"src/lib/util.go": "package lib\n",
+		"other.txt":       "not streamed",
+	}
+	for key, data := range files {
+		mustPut(t, src, key, data)
+	}
+	if err := src.MkdirEntry("src/assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := src.StreamOut(&out, "src/"); err != nil {
+		t.Fatalf("StreamOut: %v", err)
+	}
+
+	dst := streamTestStore(t) // inlines small content, unlike src
+	n, err := dst.StreamIn(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("StreamIn: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("StreamIn stored %d entries, want 4", n)
+	}
+	for key, data := range files {
+		got, err := dst.Get(key)
+		if key == "other.txt" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s outside the prefix was streamed", key)
+			}
+			continue
+		}
+		if err != nil || string(got) != data {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, data)
+		}
+	}
+	if _, ok := dst.Stat("src/assets/"); !ok {
+		t.Error("directory entry not streamed")
+	}
+
+	var again bytes.Buffer
+	if err := dst.StreamOut(&again, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), out.Bytes()) {
+		t.Error("streaming the copy back out gives different bytes")
+	}
+}
+
+func TestStreamInTruncated(t *testing.T) {
+	src := newTestStore(t)
+	mustPut(t, src, "a", "first record")
+	mustPut(t, src, "b", "second record")
+	var out bytes.Buffer
+	if err := src.StreamOut(&out, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestStore(t)
+	mustPut(t, dst, "b", "kept")
+	n, err := dst.StreamIn(bytes.NewReader(out.Bytes()[:out.Len()-3]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || n != 1 {
+		t.Fatalf("StreamIn = %d, %v; want 1, ErrUnexpectedEOF", n, err)
+	}
+	if got := mustGet(t, dst, "b"); got != "kept" {
+		t.Errorf("truncated record replaced b with %q", got)
+	}
+}
+
+func FuzzStreamIn(f *testing.F) {
+	src := streamTestStore(f)
+	for key, data := range map[string]string{"a": "x", "dir/b": "longer than inline", "e": ""} {
+		if err := src.Put(key, []byte(data)); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err := src.MkdirEntry("d"); err != nil {
+		f.Fatal(err)
+	}
+	var valid bytes.Buffer
+	if err := src.StreamOut(&valid, ""); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid.Bytes())
+	f.Add(valid.Bytes()[:valid.Len()/2])
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 1, 'k', 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 2, '_', 'x', 0, 0, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := streamTestStore(t)
+		n, err := s.StreamIn(bytes.NewReader(data))
+		if n < s.Len() {
+			t.Fatalf("StreamIn reported %d entries but stored %d", n, s.Len())
+		}
+		if err != nil {
+			return
+		}
+		// Whatever was accepted streams back out and in unchanged.
+		var out bytes.Buffer
+		if err := s.StreamOut(&out, ""); err != nil {
+			t.Fatalf("StreamOut: %v", err)
+		}
+		s2 := streamTestStore(t)
+		if _, err := s2.StreamIn(&out); err != nil {
+			t.Fatalf("StreamIn of StreamOut: %v", err)
+		}
+		want := maps.Collect(s.List(""))
+		got := maps.Collect(s2.List(""))
+		if !slices.Equal(slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want))) {
+			t.Fatalf("keys after round trip = %v, want %v", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want)))
+		}
+	})
+}
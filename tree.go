@@ -0,0 +1,281 @@
+package cafs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+// Tree objects describe one directory each, so a directory can be compared
+// with a remote by hash without its index. The encoding is a version header
+// followed by one line per child, sorted by name:
+//
+//	cafs-tree v1
+//	blob <digest> <size>\t<name>
+//	tree <digest>\t<name>
+//
+// A tree's digest is computeDigest over that text. Objects without the header
+// are read as version 1, and newer versions are rejected rather than
+// misread. Keys that aren't valid fs paths are left out, as in FS, and so are
+// keys containing line breaks, which would otherwise forge extra tree lines.
+const (
+	treeMagic   = "cafs-tree "
+	treeVersion = "v1"
+	treeHeader  = treeMagic + treeVersion + "\n"
+)
+
+type treeNode struct {
+	files map[string]Info
+	dirs  map[string]*treeNode
+}
+
+func newTreeNode() *treeNode {
+	return &treeNode{files: make(map[string]Info), dirs: make(map[string]*treeNode)}
+}
+
+// buildTree arranges the entries under prefix into directories.
+func (s *CAS) buildTree(prefix string) *treeNode {
+	root := newTreeNode()
+	for key, info := range s.List(prefix) {
+		name := strings.TrimSuffix(key, "/")
+		if !fs.ValidPath(name) || name == "." || strings.ContainsAny(name, "\r\n") {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		node := root
+		for _, dir := range parts[:len(parts)-1] {
+			node = node.dir(dir)
+		}
+		base := parts[len(parts)-1]
+		if IsDirKey(key) {
+			node.dir(base)
+		} else {
+			node.files[base] = info
+		}
+	}
+	return root
+}
+
+func (n *treeNode) dir(name string) *treeNode {
+	child, ok := n.dirs[name]
+	if !ok {
+		child = newTreeNode()
+		n.dirs[name] = child
+	}
+	return child
+}
+
+// treeEncoder computes tree object digests bottom-up. Sibling subtrees are
+// independent, so they are encoded concurrently while a worker slot is free
+// and inline otherwise; the result doesn't depend on scheduling.
+type treeEncoder struct {
+	sem chan struct{}
+	mu  sync.Mutex
+	out map[Digest][]byte // receives every tree object when non-nil
+}
+
+func newTreeEncoder(workers int, out map[Digest][]byte) *treeEncoder {
+	return &treeEncoder{sem: make(chan struct{}, max(workers-1, 0)), out: out}
+}
+
+// encode returns the digest of n's tree object. A name that is both a file
+// and a directory is shadowed by the directory, as in FS.
+func (e *treeEncoder) encode(n *treeNode) Digest {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	subtrees := make(map[string]Digest, len(n.dirs))
+	for name, child := range n.dirs {
+		select {
+		case e.sem <- struct{}{}:
+			wg.Go(func() {
+				defer func() { <-e.sem }()
+				digest := e.encode(child)
+				mu.Lock()
+				subtrees[name] = digest
+				mu.Unlock()
+			})
+		default:
+			digest := e.encode(child)
+			mu.Lock()
+			subtrees[name] = digest
+			mu.Unlock()
+		}
+	}
+	wg.Wait()
+
+	type line struct{ name, text string }
+	lines := make([]line, 0, len(n.files)+len(n.dirs))
+	for name, digest := range subtrees {
+		lines = append(lines, line{name, fmt.Sprintf("tree %s\t%s\n", digest, name)})
+	}
+	for name, info := range n.files {
+		if _, ok := n.dirs[name]; ok {
+			continue
+		}
+		lines = append(lines, line{name, fmt.Sprintf("blob %s %d\t%s\n", info.Digest, info.Size, name)})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].name < lines[j].name })
+
+	var buf bytes.Buffer
+	buf.WriteString(treeHeader)
+	for _, l := range lines {
+		buf.WriteString(l.text)
+	}
+	data := buf.Bytes()
+	digest := computeDigest(data)
+	if e.out != nil {
+		e.mu.Lock()
+		e.out[digest] = data
+		e.mu.Unlock()
+	}
+	return digest
+}
+
+// TreeHash returns the digest of the tree object for dir ("" for the root),
+// as pushed with WithPersistTrees.
+func (s *CAS) TreeHash(dir string) Digest {
+	return newTreeEncoder(s.concurrency, nil).encode(s.buildTree(dirPrefix(dir)))
+}
+
+// storeTrees writes the tree objects of the whole store to the blob store,
+// marking new ones for upload, and returns the root tree digest along with
+// every tree object, new or not, since a push must keep all of them.
+func (s *CAS) storeTrees() (Digest, map[Digest][]byte, error) {
+	trees := make(map[Digest][]byte)
+	root := newTreeEncoder(s.concurrency, trees).encode(s.buildTree(""))
+	for digest, data := range trees {
+		isNew, err := s.blobs.putWithDigest(digest, data)
+		if err != nil {
+			return "", nil, fmt.Errorf("store tree %s: %w", digest, err)
+		}
+		if isNew {
+			s.blobs.pending.Store(digest, struct{}{})
+		}
+	}
+	return root, trees, nil
+}
+
+// RemoteTreeHash returns the tree digest of dir in the remote image, fetching
+// only the manifest and the layers holding the trees along the path. Compare
+// it with TreeHash to tell whether a directory differs without pulling. The
+// image must have been pushed with WithPersistTrees.
+func (s *CAS) RemoteTreeHash(ctx context.Context, dir string) (Digest, error) {
+	if s.remote == nil {
+		return "", ErrNoRemote
+	}
+	head, err := s.remote.Head(ctx)
+	if err != nil {
+		return "", err
+	}
+	if head.Tree == "" {
+		return "", fmt.Errorf("remote %s has no tree objects: %w", s.remote, ErrNotFound)
+	}
+
+	digest := Digest(head.Tree)
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		data, err := s.fetchRemoteObject(ctx, head.Prefixes, digest)
+		if err != nil {
+			return "", err
+		}
+		if digest, err = treeChild(data, name); err != nil {
+			return "", fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+	return digest, nil
+}
+
+// fetchRemoteObject returns a blob from the local store, or else from the
+// remote layer its prefix maps to.
+func (s *CAS) fetchRemoteObject(ctx context.Context, prefixes map[string]remote.PrefixInfo, digest Digest) ([]byte, error) {
+	if data, err := s.blobs.Get(digest); err == nil {
+		return data, nil
+	}
+	info, ok := prefixes[remote.PrefixOf(string(digest))]
+	if !ok {
+		return nil, fmt.Errorf("no layer for %s: %w", digest, ErrNotFound)
+	}
+	blobs, err := s.remote.FetchLayer(ctx, info.Layer)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blobs[string(digest)]
+	if !ok || computeDigest(data) != digest {
+		return nil, fmt.Errorf("object %s: %w", digest, ErrNotFound)
+	}
+	return data, nil
+}
+
+// treeChild finds the subtree name in an encoded tree object.
+func treeChild(data []byte, name string) (Digest, error) {
+	items, err := parseTree(data)
+	if err != nil {
+		return "", err
+	}
+	item, ok := items[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if !item.dir {
+		return "", fmt.Errorf("%s is not a directory: %w", strconv.Quote(name), ErrNotFound)
+	}
+	return item.digest, nil
+}
+
+// dirPrefix turns a directory name into a List prefix.
+func dirPrefix(dir string) string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// treeItem is one line of a tree object.
+type treeItem struct {
+	dir    bool
+	digest Digest
+}
+
+// parseTree decodes a tree object into its children by name.
+func parseTree(data []byte) (map[string]treeItem, error) {
+	if version, ok := bytes.CutPrefix(data, []byte(treeMagic)); ok {
+		line, rest, ok := bytes.Cut(version, []byte("\n"))
+		if !ok {
+			return nil, fmt.Errorf("truncated tree object header %s", strconv.Quote(string(data)))
+		}
+		if string(line) != treeVersion {
+			return nil, fmt.Errorf("unsupported tree object version %s", strconv.Quote(string(line)))
+		}
+		data = rest
+	}
+
+	items := make(map[string]treeItem)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		head, name, ok := strings.Cut(sc.Text(), "\t")
+		if !ok {
+			return nil, fmt.Errorf("malformed tree line %s", strconv.Quote(sc.Text()))
+		}
+		fields := strings.Fields(head)
+		switch {
+		case len(fields) == 2 && fields[0] == "tree":
+			items[name] = treeItem{dir: true, digest: Digest(fields[1])}
+		case len(fields) == 3 && fields[0] == "blob":
+			items[name] = treeItem{digest: Digest(fields[1])}
+		default:
+			return nil, fmt.Errorf("malformed tree line %s", strconv.Quote(sc.Text()))
+		}
+	}
+	return items, sc.Err()
+}
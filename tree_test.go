@@ -0,0 +1,238 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestRemoteTreeHash(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	s := newTestStore(t, WithRemote(ref), WithPersistTrees())
+	mustPut(t, s, "src/main.go", "package main")
+	mustPut(t, s, "src/lib/util.go", "package lib")
+	mustPut(t, s, "docs/README.md", "# docs")
+	mustPut(t, s, "docs/evil\nblob sha256:00 1\tforged", "x")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	// A store with nothing local answers from the pushed tree objects.
+	fresh := newTestStore(t, WithRemote(ref))
+	for _, dir := range []string{"", "src", "src/lib", "docs"} {
+		got, err := fresh.RemoteTreeHash(ctx, dir)
+		if err != nil {
+			t.Fatalf("RemoteTreeHash(%q): %v", dir, err)
+		}
+		if want := s.TreeHash(dir); got != want {
+			t.Errorf("RemoteTreeHash(%q) = %s, want %s", dir, got, want)
+		}
+	}
+	if _, err := fresh.RemoteTreeHash(ctx, "src/main.go"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RemoteTreeHash of a file: err = %v, want ErrNotFound", err)
+	}
+
+	// Only the changed directory and its ancestors differ.
+	mustPut(t, s, "src/lib/util.go", "package lib // changed")
+	for dir, differs := range map[string]bool{"": true, "src": true, "src/lib": true, "docs": false} {
+		remote, err := fresh.RemoteTreeHash(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := remote != s.TreeHash(dir); got != differs {
+			t.Errorf("%q differs = %v, want %v", dir, got, differs)
+		}
+	}
+
+	// A key with a line break can't forge an entry in its tree.
+	items, err := parseTree(mustGetBlob(t, s, s.TreeHash("docs")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("docs tree has %d items, want only README.md", len(items))
+	}
+}
+
+func TestRemoteTreeHashAfterIncrementalPush(t *testing.T) {
+	ctx := context.Background()
+	ref := newTestRegistry(t) + "/repo:main"
+
+	s := newTestStore(t, WithRemote(ref), WithPersistTrees())
+	mustPut(t, s, "src/main.go", "package main")
+	mustPut(t, s, "docs/guide/intro.md", "# intro")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+	mustPut(t, s, "src/main.go", "package main // changed")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("second Push: %v", err)
+	}
+
+	// The docs trees were only uploaded by the first push.
+	fresh := newTestStore(t, WithRemote(ref))
+	for _, dir := range []string{"", "src", "docs", "docs/guide"} {
+		got, err := fresh.RemoteTreeHash(ctx, dir)
+		if err != nil {
+			t.Fatalf("RemoteTreeHash(%q): %v", dir, err)
+		}
+		if want := s.TreeHash(dir); got != want {
+			t.Errorf("RemoteTreeHash(%q) = %s, want %s", dir, got, want)
+		}
+	}
+
+	root, err := fresh.RemoteTreeHash(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := fresh.DiffTrees(ctx, root, fresh.TreeHash(""))
+	if err != nil {
+		t.Fatalf("DiffTrees: %v", err)
+	}
+	var removed []string
+	for _, c := range changes {
+		removed = append(removed, c.Path)
+	}
+	if want := []string{"docs/guide/intro.md", "src/main.go"}; !slices.Equal(removed, want) {
+		t.Errorf("DiffTrees against an empty store = %v, want %v", removed, want)
+	}
+}
+
+func TestRemoteTreeHashNeedsPersistTrees(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithRemote(newTestRegistry(t)+"/repo:main"))
+	mustPut(t, s, "a.txt", "a")
+	if err := s.Push(ctx); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if _, err := s.RemoteTreeHash(ctx, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RemoteTreeHash without trees: err = %v, want ErrNotFound", err)
+	}
+}
+
+func mustGetBlob(t *testing.T, s *CAS, digest Digest) []byte {
+	t.Helper()
+	data, err := s.blobs.Get(digest)
+	if err != nil {
+		t.Fatalf("blob %s: %v", digest, err)
+	}
+	return data
+}
+
+// fillTree adds width^depth files, width to a directory, without writing
+// blobs: tree encoding only reads entries.
+func fillTree(s *CAS, width, depth int) {
+	var fill func(prefix string, level int)
+	fill = func(prefix string, level int) {
+		for i := range width {
+			if level == depth {
+				name := fmt.Sprintf("%sf%d", prefix, i)
+				s.setEntry(name, Info{Digest: computeDigest([]byte(name)), Size: int64(len(name))})
+			} else {
+				fill(fmt.Sprintf("%sd%d/", prefix, i), level+1)
+			}
+		}
+	}
+	fill("", 1)
+}
+
+func TestTreeEncodingIsDeterministic(t *testing.T) {
+	s := newTestStore(t)
+	fillTree(s, 6, 4)
+	tree := s.buildTree("")
+
+	serialObjects := make(map[Digest][]byte)
+	serial := newTreeEncoder(1, serialObjects).encode(tree)
+	for _, workers := range []int{2, 8, 64} {
+		objects := make(map[Digest][]byte)
+		if got := newTreeEncoder(workers, objects).encode(tree); got != serial {
+			t.Errorf("%d workers: root %s, serial root %s", workers, got, serial)
+		}
+		if !maps.EqualFunc(objects, serialObjects, func(a, b []byte) bool { return string(a) == string(b) }) {
+			t.Errorf("%d workers: tree objects differ from the serial encoding", workers)
+		}
+	}
+	if got := s.TreeHash(""); got != serial {
+		t.Errorf("TreeHash = %s, want %s", got, serial)
+	}
+}
+
+func BenchmarkTreeEncoding(b *testing.B) {
+	s, err := Open("bench:main", WithCacheDir(b.TempDir()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	cas := s.(*CAS)
+	fillTree(cas, 10, 4) // 10,000 files in 1,111 directories
+	tree := cas.buildTree("")
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for b.Loop() {
+				newTreeEncoder(workers, make(map[Digest][]byte)).encode(tree)
+			}
+		})
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	const (
+		blob = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		sub  = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	body := "blob " + blob + " 5\ta.txt\ntree " + sub + "\tdir\n"
+	want := map[string]treeItem{"a.txt": {digest: blob}, "dir": {dir: true, digest: sub}}
+
+	tests := []struct {
+		name string
+		data string
+		want map[string]treeItem // nil for an error
+	}{
+		{"v1", treeHeader + body, want},
+		{"legacy without header", body, want},
+		{"empty v1", treeHeader, map[string]treeItem{}},
+		{"header without newline", "cafs-tree v1", nil},
+		{"future version", "cafs-tree v2\n" + body, nil},
+		{"malformed line", treeHeader + "blob " + blob + "\n", nil},
+	}
+	for _, tt := range tests {
+		items, err := parseTree([]byte(tt.data))
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s: parsed %v, want an error", tt.name, items)
+		case tt.want != nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != nil && !maps.Equal(items, tt.want):
+			t.Errorf("%s: items = %v, want %v", tt.name, items, tt.want)
+		}
+	}
+}
+
+func TestTreeObjectsRoundTrip(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a.txt", "a")
+	mustPut(t, s, "dir/b.txt", "b")
+	objects := make(map[Digest][]byte)
+	root := newTreeEncoder(1, objects).encode(s.buildTree(""))
+
+	items, err := parseTree(objects[root])
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := s.Stat("a.txt")
+	if items["a.txt"] != (treeItem{digest: a.Digest}) || !items["dir"].dir {
+		t.Errorf("root items = %v", items)
+	}
+	if items["dir"].digest != s.TreeHash("dir") {
+		t.Errorf("dir = %s, TreeHash(dir) = %s", items["dir"].digest, s.TreeHash("dir"))
+	}
+	if _, ok := objects[items["dir"].digest]; !ok {
+		t.Error("subtree object not encoded")
+	}
+}
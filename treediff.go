@@ -0,0 +1,150 @@
+package cafs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aweris/cafs/internal/remote"
+)
+
+// ChangeKind says how a path differs between two trees.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change is one file that differs between two trees. From is empty for added
+// files and To for removed ones.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	From Digest
+	To   Digest
+}
+
+// DiffTrees compares two tree digests, as returned by TreeHash or
+// RemoteTreeHash, and returns the changed files sorted by path. Subtrees with
+// equal digests are skipped without being read, so the work is proportional
+// to the difference rather than the size of the trees. Tree objects are read
+// from the blob store, then from the local entries, then from the remote.
+func (s *CAS) DiffTrees(ctx context.Context, a, b Digest) ([]Change, error) {
+	d := &treeDiffer{s: s, ctx: ctx}
+	if err := d.diff("", a, b); err != nil {
+		return nil, err
+	}
+	sort.Slice(d.changes, func(i, j int) bool { return d.changes[i].Path < d.changes[j].Path })
+	return d.changes, nil
+}
+
+type treeDiffer struct {
+	s       *CAS
+	ctx     context.Context
+	changes []Change
+
+	local    map[Digest][]byte // tree objects of the local entries, built on first miss
+	prefixes map[string]remote.PrefixInfo
+	reads    int // tree objects read so far
+}
+
+func (d *treeDiffer) diff(path string, a, b Digest) error {
+	if a == b {
+		return nil
+	}
+	var from, to map[string]treeItem
+	var err error
+	if a != "" {
+		if from, err = d.read(a); err != nil {
+			return err
+		}
+	}
+	if b != "" {
+		if to, err = d.read(b); err != nil {
+			return err
+		}
+	}
+
+	for name, x := range from {
+		y, ok := to[name]
+		switch {
+		case !ok:
+			err = d.one(path+name, x, treeItem{}, ChangeRemoved)
+		case x == y:
+		case x.dir && y.dir:
+			err = d.diff(path+name+"/", x.digest, y.digest)
+		case !x.dir && !y.dir:
+			d.changes = append(d.changes, Change{Path: path + name, Kind: ChangeModified, From: x.digest, To: y.digest})
+		default: // a file replaced by a directory or the reverse
+			if err = d.one(path+name, x, treeItem{}, ChangeRemoved); err == nil {
+				err = d.one(path+name, treeItem{}, y, ChangeAdded)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for name, y := range to {
+		if _, ok := from[name]; !ok {
+			if err := d.one(path+name, treeItem{}, y, ChangeAdded); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// one records a file that exists on one side only, or every file below a
+// directory that does.
+func (d *treeDiffer) one(path string, from, to treeItem, kind ChangeKind) error {
+	item := from
+	if kind == ChangeAdded {
+		item = to
+	}
+	if !item.dir {
+		d.changes = append(d.changes, Change{Path: path, Kind: kind, From: from.digest, To: to.digest})
+		return nil
+	}
+	if kind == ChangeAdded {
+		return d.diff(path+"/", "", item.digest)
+	}
+	return d.diff(path+"/", item.digest, "")
+}
+
+// read loads and parses a tree object.
+func (d *treeDiffer) read(digest Digest) (map[string]treeItem, error) {
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+	d.reads++
+	data, err := d.s.blobs.Get(digest)
+	if err != nil {
+		if d.local == nil {
+			d.local = make(map[Digest][]byte)
+			newTreeEncoder(d.s.concurrency, d.local).encode(d.s.buildTree(""))
+		}
+		var ok bool
+		if data, ok = d.local[digest]; !ok {
+			if data, err = d.fetch(digest); err != nil {
+				return nil, fmt.Errorf("tree %s: %w", digest, err)
+			}
+		}
+	}
+	return parseTree(data)
+}
+
+func (d *treeDiffer) fetch(digest Digest) ([]byte, error) {
+	if d.s.remote == nil {
+		return nil, ErrNotFound
+	}
+	if d.prefixes == nil {
+		head, err := d.s.remote.Head(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.prefixes = head.Prefixes
+	}
+	return d.s.fetchRemoteObject(d.ctx, d.prefixes, digest)
+}
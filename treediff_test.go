@@ -0,0 +1,80 @@
+package cafs
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "keep.txt", "same")
+	mustPut(t, s, "edit.txt", "before")
+	mustPut(t, s, "gone.txt", "removed")
+	mustPut(t, s, "old/a.txt", "a")
+	mustPut(t, s, "swap", "file, then a directory")
+	before, _, err := s.storeTrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustPut(t, s, "edit.txt", "after")
+	s.Delete("gone.txt")
+	s.Delete("old/a.txt")
+	mustPut(t, s, "new/b.txt", "b")
+	s.Delete("swap")
+	mustPut(t, s, "swap/c.txt", "c")
+	after := s.TreeHash("") // read from the local entries
+
+	changes, err := s.DiffTrees(context.Background(), before, after)
+	if err != nil {
+		t.Fatalf("DiffTrees: %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, fmt.Sprintf("%s %s", c.Kind, c.Path))
+	}
+	want := []string{
+		"modified edit.txt",
+		"removed gone.txt",
+		"added new/b.txt",
+		"removed old/a.txt",
+		"removed swap",
+		"added swap/c.txt",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("DiffTrees =\n%v\nwant\n%v", got, want)
+	}
+
+	if changes, err := s.DiffTrees(context.Background(), after, after); err != nil || len(changes) != 0 {
+		t.Errorf("DiffTrees of a tree with itself = %v, %v", changes, err)
+	}
+}
+
+func TestDiffTreesSkipsEqualSubtrees(t *testing.T) {
+	s := newTestStore(t)
+	fillTree(s, 8, 4) // 4,096 files in 585 directories
+	before, _, err := s.storeTrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const path = "d3/d5/d1/f7"
+	s.setEntry(path, Info{Digest: computeDigest([]byte("edited")), Size: 6})
+	after, _, err := s.storeTrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &treeDiffer{s: s, ctx: context.Background()}
+	if err := d.diff("", before, after); err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if len(d.changes) != 1 || d.changes[0].Path != path || d.changes[0].Kind != ChangeModified {
+		t.Errorf("changes = %+v, want %s modified", d.changes, path)
+	}
+	// Both sides of the root and the three directories above the file.
+	if d.reads != 8 {
+		t.Errorf("read %d tree objects, want 8", d.reads)
+	}
+}
@@ -0,0 +1,50 @@
+package cafs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Calibration for AutoTuneConcurrency: each level transfers that many probe
+// layers at once.
+var (
+	tuneLevels    = []int{1, 2, 4, 8, 16, 32}
+	tuneProbeSize = 512 << 10
+)
+
+// AutoTuneConcurrency measures upload and download throughput against the
+// remote at increasing parallelism and returns the lowest level within 10%
+// of the best, to pass to WithConcurrency before a large transfer. It stops
+// early once doubling no longer helps. The probe layers are random, a few
+// megabytes in total, and left unreferenced for the registry to collect.
+func (s *CAS) AutoTuneConcurrency(ctx context.Context) (int, error) {
+	if s.remote == nil {
+		return 0, ErrNoRemote
+	}
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	throughput := make(map[int]float64) // bytes per second
+	var best float64
+	for _, n := range tuneLevels {
+		elapsed, err := s.remote.Probe(ctx, n, tuneProbeSize)
+		if err != nil {
+			return 0, fmt.Errorf("probe at %d: %w", n, err)
+		}
+		tp := float64(n*tuneProbeSize) / max(elapsed, time.Microsecond).Seconds()
+		throughput[n] = tp
+		if tp < best*1.1 {
+			break
+		}
+		best = max(best, tp)
+	}
+
+	for _, n := range tuneLevels {
+		if throughput[n] >= best*0.9 {
+			return n, nil
+		}
+	}
+	return tuneLevels[0], nil
+}
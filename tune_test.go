@@ -0,0 +1,57 @@
+package cafs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+// newThrottledRegistry models a registry with fixed latency per blob
+// transfer that serves at most slots transfers at a time, so throughput
+// stops growing past slots parallel transfers.
+func newThrottledRegistry(t *testing.T, slots int, latency time.Duration) string {
+	t.Helper()
+	sem := make(chan struct{}, slots)
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transfer := strings.Contains(r.URL.Path, "/blobs/") &&
+			(r.Method == http.MethodPut || r.Method == http.MethodGet)
+		if transfer {
+			sem <- struct{}{}
+			time.Sleep(latency)
+			<-sem
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestAutoTuneConcurrency(t *testing.T) {
+	defer func(size int) { tuneProbeSize = size }(tuneProbeSize)
+	tuneProbeSize = 16 << 10
+
+	ref := newThrottledRegistry(t, 4, 40*time.Millisecond) + "/repo:main"
+	s := newTestStore(t, WithRemote(ref))
+	n, err := s.AutoTuneConcurrency(context.Background())
+	if err != nil {
+		t.Fatalf("AutoTuneConcurrency: %v", err)
+	}
+	if n < 4 || n > 8 {
+		t.Errorf("AutoTuneConcurrency = %d for a registry serving 4 transfers at once", n)
+	}
+}
+
+func TestAutoTuneConcurrencyNeedsRemote(t *testing.T) {
+	if _, err := newTestStore(t).AutoTuneConcurrency(context.Background()); !errors.Is(err, ErrNoRemote) {
+		t.Errorf("err = %v, want ErrNoRemote", err)
+	}
+}
@@ -0,0 +1,139 @@
+package cafs
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// tx implements Tx. Staged maps keys to their new Info, or nil for deletes;
+// data holds the content of staged Puts.
+type tx struct {
+	s      *CAS
+	mu     sync.Mutex
+	staged map[string]*Info
+	data   map[string][]byte
+	done   bool
+}
+
+// Begin starts a transaction. Its Puts are held in memory and touch neither
+// the blob store nor the entries until Commit, so a rolled-back transaction
+// leaves nothing behind.
+func (s *CAS) Begin() Tx {
+	return &tx{s: s, staged: make(map[string]*Info), data: make(map[string][]byte)}
+}
+
+func (t *tx) Put(key string, data []byte, opts ...Option) error {
+	if t.s.readOnly {
+		return ErrReadOnly
+	}
+	key, err := t.s.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if err := validateFileKey(key); err != nil {
+		return err
+	}
+
+	info := Info{Digest: computeDigest(data), Size: int64(len(data))}
+	for _, opt := range opts {
+		opt(&info)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxDone
+	}
+	t.staged[key] = &info
+	t.data[key] = bytes.Clone(data)
+	return nil
+}
+
+func (t *tx) Delete(key string) {
+	key, err := t.s.checkKey(key)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.staged[key] = nil
+		delete(t.data, key)
+	}
+}
+
+// Commit applies every staged change in one step. Blobs are written and the
+// audit log is appended first; only then are the entries changed, all under
+// the index lock, so readers such as Sync and StatMulti see the transaction
+// either entirely or not at all, and a failure leaves the entries untouched.
+func (t *tx) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	if t.s.readOnly {
+		return ErrReadOnly
+	}
+
+	// Written without queuing them for push, so a failed commit leaves
+	// nothing but blobs for GC.
+	for _, key := range slices.Sorted(maps.Keys(t.data)) {
+		if _, err := t.s.stageBlob(t.data[key]); err != nil {
+			return err
+		}
+	}
+
+	t.s.indexMu.Lock()
+	defer t.s.indexMu.Unlock()
+
+	var changed []string
+	for _, key := range slices.Sorted(maps.Keys(t.staged)) {
+		info := t.staged[key]
+		old, ok := t.s.lookup(key)
+		if info == nil && !ok || info != nil && ok && sameEntry(old, *info) {
+			continue
+		}
+		changed = append(changed, key)
+	}
+	for _, key := range changed {
+		var err error
+		if info := t.staged[key]; info == nil {
+			old, _ := t.s.lookup(key)
+			err = t.s.audit.record("delete", key, old.Digest, "")
+		} else {
+			err = t.s.audit.record("put", key, info.Digest, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range changed {
+		info := t.staged[key]
+		if info == nil {
+			old, _ := t.s.entries.LoadAndDelete(key)
+			prev := old.(Info)
+			t.s.metaIndex.update(key, &prev, nil)
+			continue
+		}
+		t.s.blobs.queue(info.Digest)
+		t.s.setEntry(key, *info)
+	}
+	if len(changed) > 0 {
+		t.s.dirty.Store(true)
+	}
+	return nil
+}
+
+// Rollback discards staged changes. It is a no-op after Commit.
+func (t *tx) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	t.staged = nil
+	t.data = nil
+}
@@ -0,0 +1,130 @@
+package cafs
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "old a")
+	mustPut(t, s, "b", "old b")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := s.Begin()
+	if err := tx.Put("a", []byte("new a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("c", []byte("new c")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Delete("b")
+	if err := tx.Put("_reserved", nil); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("tx Put of a reserved key: err = %v", err)
+	}
+
+	if got := mustGet(t, s, "a"); got != "old a" || s.Dirty() {
+		t.Errorf("before Commit: a = %q, dirty = %v", got, s.Dirty())
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := mustGet(t, s, "a"); got != "new a" {
+		t.Errorf("a = %q", got)
+	}
+	if got := mustGet(t, s, "c"); got != "new c" {
+		t.Errorf("c = %q", got)
+	}
+	if _, ok := s.Stat("b"); ok {
+		t.Error("b not deleted")
+	}
+	c, _ := s.Stat("c")
+	if !s.Dirty() || !isPending(s, c.Digest) {
+		t.Errorf("after Commit: dirty = %v, c pending = %v", s.Dirty(), isPending(s, c.Digest))
+	}
+
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("second Commit: err = %v, want ErrTxDone", err)
+	}
+	if err := tx.Put("d", nil); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Put after Commit: err = %v, want ErrTxDone", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	s := newTestStore(t)
+	mustPut(t, s, "a", "old a")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := s.Begin()
+	if err := tx.Put("a", []byte("discarded")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Delete("a")
+	if err := tx.Put("b", []byte("discarded too")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if got := mustGet(t, s, "a"); got != "old a" {
+		t.Errorf("a = %q after Rollback", got)
+	}
+	if _, ok := s.Stat("b"); ok || s.Dirty() {
+		t.Errorf("Rollback left b = %v, dirty = %v", ok, s.Dirty())
+	}
+	digest := computeDigest([]byte("discarded too"))
+	if _, err := os.Stat(s.Path(digest)); !os.IsNotExist(err) {
+		t.Errorf("rolled-back content written to disk (stat err %v)", err)
+	}
+	if isPending(s, digest) {
+		t.Error("rolled-back content queued for push")
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Commit after Rollback: err = %v, want ErrTxDone", err)
+	}
+}
+
+// failingWriter fails every write once fail is set.
+type failingWriter struct{ fail atomic.Bool }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.fail.Load() {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestTxCommitIsAllOrNothing(t *testing.T) {
+	w := new(failingWriter)
+	s := newTestStore(t, WithAuditLog(w))
+	mustPut(t, s, "a", "old a")
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := s.Begin()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tx.Put(key, []byte("new "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.fail.Store(true)
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit succeeded without an audit log")
+	}
+	if got := mustGet(t, s, "a"); got != "old a" || s.Len() != 1 || s.Dirty() {
+		t.Errorf("failed Commit changed the store: a = %q, len = %d, dirty = %v", got, s.Len(), s.Dirty())
+	}
+	if digest := computeDigest([]byte("new b")); isPending(s, digest) {
+		t.Error("failed Commit queued its blobs for push")
+	}
+	if removed, err := s.GC(); err != nil || removed != 3 {
+		t.Errorf("GC = %d, %v; want the 3 uncommitted blobs removed", removed, err)
+	}
+}